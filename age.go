@@ -0,0 +1,44 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	gwDownlinkAgeDesc = prometheus.NewDesc("gateway_last_downlink_age_seconds",
+		"Time since the gateway received its last downlink.", []string{"gateway"}, nil)
+	gwTxAckAgeDesc = prometheus.NewDesc("gateway_last_txack_age_seconds",
+		"Time since the gateway sent its last TX acknowledgment.", []string{"gateway"}, nil)
+)
+
+// ageCollector computes the age of the last downlink and TX acknowledgment
+// of every gateway at scrape time. Gateways that never had one are skipped,
+// so receive-only gateways don't look stuck.
+type ageCollector struct {
+	store Store
+	clock Clock
+}
+
+func (a ageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- gwDownlinkAgeDesc
+	ch <- gwTxAckAgeDesc
+}
+
+func (a ageCollector) Collect(ch chan<- prometheus.Metric) {
+	now := a.clock.Now()
+	seen := make(map[string]bool)
+	for _, gw := range a.store.Snapshot() {
+		// Relabelling may map several gateways to the same label, which
+		// would fail the whole scrape with duplicate series.
+		gwid := relabelGateway(gw.id)
+		if seen[gwid] {
+			continue
+		}
+		seen[gwid] = true
+
+		if gw.downlinkCount != 0 {
+			ch <- prometheus.MustNewConstMetric(gwDownlinkAgeDesc, prometheus.GaugeValue, now.Sub(gw.downlinkTime).Seconds(), gwid)
+		}
+		if gw.txAckCount != 0 {
+			ch <- prometheus.MustNewConstMetric(gwTxAckAgeDesc, prometheus.GaugeValue, now.Sub(gw.txAckTime).Seconds(), gwid)
+		}
+	}
+}
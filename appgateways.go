@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var appActiveGatewaysDesc = prometheus.NewDesc(
+	"application_active_gateways",
+	"Number of distinct gateways that received uplinks of an application within the window.",
+	[]string{"application"}, nil,
+)
+
+// appGateways tracks which gateways received uplinks for which application.
+// It is a prometheus.Collector, so the windowed count is computed at scrape
+// time.
+type appGateways struct {
+	window time.Duration
+	clock  Clock
+
+	mu   sync.Mutex
+	seen map[string]map[string]time.Time
+}
+
+func newAppGateways(window time.Duration, clock Clock) *appGateways {
+	return &appGateways{
+		window: window,
+		clock:  clock,
+		seen:   make(map[string]map[string]time.Time),
+	}
+}
+
+func (a *appGateways) markSeen(app string, gw string, t time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	gws, ok := a.seen[app]
+	if !ok {
+		gws = make(map[string]time.Time)
+		a.seen[app] = gws
+	}
+	if t.After(gws[gw]) {
+		gws[gw] = t
+	}
+}
+
+// counts returns the number of gateways per application seen after
+// now-window and forgets about everything older.
+func (a *appGateways) counts(now time.Time) map[string]int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rtn := make(map[string]int, len(a.seen))
+	for app, gws := range a.seen {
+		for gw, t := range gws {
+			if now.Sub(t) > a.window {
+				delete(gws, gw)
+			}
+		}
+		if len(gws) == 0 {
+			delete(a.seen, app)
+			continue
+		}
+		rtn[app] = len(gws)
+	}
+
+	return rtn
+}
+
+func (a *appGateways) Describe(ch chan<- *prometheus.Desc) {
+	ch <- appActiveGatewaysDesc
+}
+
+func (a *appGateways) Collect(ch chan<- prometheus.Metric) {
+	for app, n := range a.counts(a.clock.Now()) {
+		ch <- prometheus.MustNewConstMetric(appActiveGatewaysDesc, prometheus.GaugeValue, float64(n), app)
+	}
+}
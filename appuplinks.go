@@ -0,0 +1,65 @@
+package main
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// otherApplication is the application label of uplinks of applications
+// beyond the limit.
+const otherApplication = "other"
+
+var gwUplinksByApp = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_uplinks_by_application_total",
+	Help: "Number of forwarded application uplinks received by the gateway, by application.",
+}, []string{"gateway", "application"})
+
+// appLabels bounds the application label of gateway_uplinks_by_application_total.
+// Applications on the allowlist get their own label. Without an allowlist
+// the first max applications seen do, all others are counted as "other".
+type appLabels struct {
+	allow []string
+	max   int
+
+	mu   sync.Mutex
+	seen []string
+}
+
+// enabled reports whether the metric was configured at all.
+func (a *appLabels) enabled() bool {
+	return len(a.allow) > 0 || a.max > 0
+}
+
+func (a *appLabels) label(app string) string {
+	if len(a.allow) > 0 {
+		if slices.Contains(a.allow, app) {
+			return app
+		}
+		return otherApplication
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if slices.Contains(a.seen, app) {
+		return app
+	}
+	if len(a.seen) < a.max {
+		a.seen = append(a.seen, app)
+		return app
+	}
+
+	return otherApplication
+}
+
+// countAppUplink counts an uplink of app received by gwid.
+func (a *appLabels) countAppUplink(app, gwid string) {
+	if !a.enabled() {
+		return
+	}
+
+	gwUplinksByApp.WithLabelValues(relabelGateway(gwid), a.label(app)).Inc()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.thethings.network/lorawan-stack/v3/pkg/events"
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+)
+
+var (
+	gwUplinkBytesDesc = prometheus.NewDesc("gateway_uplink_bytes_total",
+		"Sum of the PHYPayload sizes of the uplinks received by the gateway.", []string{"gateway"}, nil)
+	gwDownlinkBytesDesc = prometheus.NewDesc("gateway_downlink_bytes_total",
+		"Sum of the PHYPayload sizes of the downlinks sent to the gateway.", []string{"gateway"}, nil)
+)
+
+// bandwidthMetrics makes the uplink and downlink handlers count the payload
+// bytes, set with LYTGAE_BANDWIDTH_METRICS.
+var bandwidthMetrics = false
+
+// bandwidthCollector exports the payload bytes accumulated in the store,
+// which estimate the backhaul bandwidth of the gateways.
+type bandwidthCollector struct {
+	store Store
+}
+
+func (b bandwidthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- gwUplinkBytesDesc
+	ch <- gwDownlinkBytesDesc
+}
+
+func (b bandwidthCollector) Collect(ch chan<- prometheus.Metric) {
+	seen := make(map[string]bool)
+	for _, gw := range b.store.Snapshot() {
+		gwid := relabelGateway(gw.id)
+		if seen[gwid] {
+			continue
+		}
+		seen[gwid] = true
+
+		ch <- prometheus.MustNewConstMetric(gwUplinkBytesDesc, prometheus.CounterValue, float64(gw.uplinkBytes), gwid)
+		ch <- prometheus.MustNewConstMetric(gwDownlinkBytesDesc, prometheus.CounterValue, float64(gw.downlinkBytes), gwid)
+	}
+}
+
+// addPayloadBytes adds n bytes to the uplink or downlink bytes of a
+// gateway. Gateways without connection stats are not in the store yet and
+// skipped.
+func addPayloadBytes(store Store, gwid string, uplink bool, n int) {
+	gw, ok := store.Get(gwid)
+	if !ok {
+		return
+	}
+
+	if uplink {
+		gw.uplinkBytes += uint64(n)
+	} else {
+		gw.downlinkBytes += uint64(n)
+	}
+	store.Upsert(gw)
+}
+
+// handleDownlinkBytes adds the payload of a sent downlink to the bytes of
+// the gateway. Downlinks without payload count as zero bytes.
+func handleDownlinkBytes(ev events.Event, store Store) {
+	if !bandwidthMetrics {
+		return
+	}
+	data, ok := ev.Data().(*ttnpb.DownlinkMessage)
+	if !ok {
+		log.Printf("event data seems to be of type %T", ev.Data())
+		return
+	}
+
+	for _, gwid := range eventGateways(ev) {
+		addPayloadBytes(store, gwid, false, len(data.GetRawPayload()))
+	}
+}
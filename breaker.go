@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var (
+	notifierState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lytgae_notifier_state",
+		Help: "Circuit breaker state of the notifier, 0 closed, 1 open, 2 half-open.",
+	}, []string{"notifier"})
+
+	errBreakerOpen = errors.New("circuit breaker open")
+)
+
+// breaker is a circuit breaker for calls to external notifiers. It opens
+// after threshold consecutive failures and skips all calls for cooldown.
+// Afterwards a single call is let through to probe whether the notifier
+// recovered.
+type breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	clock     Clock
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+}
+
+func newBreaker(name string, threshold int, cooldown time.Duration, clock Clock) *breaker {
+	notifierState.WithLabelValues(name).Set(breakerClosed)
+
+	return &breaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		clock:     clock,
+	}
+}
+
+// call runs f unless the breaker is open, in which case errBreakerOpen is
+// returned without calling f.
+func (b *breaker) call(f func() error) error {
+	if !b.allow() {
+		return errBreakerOpen
+	}
+
+	err := f()
+	b.done(err)
+
+	return err
+}
+
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.clock.Now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		return true
+	case breakerHalfOpen:
+		// Only the probe is allowed until it finished.
+		return false
+	}
+
+	return true
+}
+
+func (b *breaker) done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.clock.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *breaker) setState(state int) {
+	b.state = state
+	notifierState.WithLabelValues(b.name).Set(float64(state))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBreakerCooldown(t *testing.T) {
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	b := newBreaker("test", 2, time.Minute, clock)
+	fail := errors.New("failed")
+	calls := 0
+	call := func(err error) error {
+		return b.call(func() error {
+			calls++
+			return err
+		})
+	}
+	state := func() float64 {
+		return testutil.ToFloat64(notifierState.WithLabelValues("test"))
+	}
+
+	call(fail)
+	if state() != breakerClosed {
+		t.Fatalf("state = %g after one failure, want closed", state())
+	}
+	call(fail)
+	if state() != breakerOpen {
+		t.Fatalf("state = %g after two failures, want open", state())
+	}
+
+	clock.Advance(time.Minute - time.Second)
+	if err := call(nil); err != errBreakerOpen || calls != 2 {
+		t.Fatalf("call within the cooldown = %v with %d calls, want errBreakerOpen", err, calls)
+	}
+
+	// The failed probe opens the breaker for another cooldown.
+	clock.Advance(time.Second)
+	if err := call(fail); err != fail || calls != 3 {
+		t.Fatalf("probe = %v with %d calls, want the probe to run", err, calls)
+	}
+	if state() != breakerOpen {
+		t.Fatalf("state = %g after a failed probe, want open", state())
+	}
+	clock.Advance(time.Second)
+	if err := call(nil); err != errBreakerOpen {
+		t.Fatalf("call after a failed probe = %v, want errBreakerOpen", err)
+	}
+
+	clock.Advance(time.Minute)
+	if err := call(nil); err != nil || calls != 4 {
+		t.Fatalf("probe = %v with %d calls, want success", err, calls)
+	}
+	if state() != breakerClosed {
+		t.Errorf("state = %g after a successful probe, want closed", state())
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+)
+
+// keyCapabilities are the gateway rights lytgae relies on, in the order
+// they are listed by check-key. RIGHT_GATEWAY_ALL implies all of them.
+var keyCapabilities = []struct {
+	name  string
+	right ttnpb.Right
+}{
+	{"gateway info", ttnpb.Right_RIGHT_GATEWAY_INFO},
+	{"read stats", ttnpb.Right_RIGHT_GATEWAY_STATUS_READ},
+	{"stream traffic", ttnpb.Right_RIGHT_GATEWAY_TRAFFIC_READ},
+}
+
+// checkKeys implements the check-key command. For every API key it lists
+// the gateways like the exporter does on startup and asks for the rights
+// the key has on each of them. It returns an error if any key lacks a
+// capability, so it can gate a deployment.
+func checkKeys(ctx context.Context, cfg *Config, out io.Writer) error {
+	if len(cfg.APIKeys) == 0 {
+		return fmt.Errorf("LYTGAE_APIKEY is not set")
+	}
+
+	// Falling back to the cache would hide a key that can not list.
+	ccfg := *cfg
+	ccfg.GatewayCache = ""
+
+	var failed []string
+	for i, k := range cfg.APIKeys {
+		if !checkKey(ctx, &ccfg, i, out) {
+			failed = append(failed, keyName(k.Key, i))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("missing rights for key %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// checkKey prints the capability summary of one key and reports whether
+// it has all capabilities.
+func checkKey(ctx context.Context, cfg *Config, key int, out io.Writer) bool {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "key %s:\n", keyName(cfg.APIKeys[key].Key, key))
+
+	c, err := NewClient(ctx, cfg, key)
+	if err != nil {
+		fmt.Fprintf(w, "  list gateways:\tno, %v\n", err)
+		return false
+	}
+	defer c.Close()
+
+	if len(cfg.Gateways) == 0 {
+		fmt.Fprintf(w, "  list gateways:\tok, %d gateways\n", c.found)
+	} else {
+		fmt.Fprintf(w, "  list gateways:\tnot used, LYTGAE_GW is set\n")
+	}
+	if len(c.gateways) == 0 {
+		fmt.Fprintf(w, "  gateways:\tnone, %s\n", c.emptyReason())
+		return true
+	}
+
+	missing := make([][]string, len(keyCapabilities))
+	access := ttnpb.NewGatewayAccessClient(c.conn)
+	for _, id := range c.gateways {
+		ids := id.GetGatewayIds()
+		rights, err := access.ListRights(c.ctx, ids)
+		if err != nil {
+			fmt.Fprintf(w, "  %s:\t%v\n", ids.GetGatewayId(), err)
+		}
+		all := slices.Contains(rights.GetRights(), ttnpb.Right_RIGHT_GATEWAY_ALL)
+		for i, kc := range keyCapabilities {
+			if !all && !slices.Contains(rights.GetRights(), kc.right) {
+				missing[i] = append(missing[i], ids.GetGatewayId())
+			}
+		}
+	}
+
+	ok := true
+	for i, kc := range keyCapabilities {
+		n := len(c.gateways) - len(missing[i])
+		if len(missing[i]) == 0 {
+			fmt.Fprintf(w, "  %s:\tok, %d/%d gateways\n", kc.name, n, len(c.gateways))
+			continue
+		}
+		ok = false
+		fmt.Fprintf(w, "  %s:\t%d/%d gateways, missing for %s\n", kc.name, n, len(c.gateways), strings.Join(missing[i], ", "))
+	}
+
+	return ok
+}
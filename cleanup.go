@@ -0,0 +1,75 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type partialDeleter interface {
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+// gatewayVecs returns all metric vectors with a gateway label.
+func gatewayVecs() []partialDeleter {
+	vecs := []partialDeleter{
+		gwTime, gwCount, gwUplinkPayload, gwSNRAvg, gwConnected,
+		gwStatsInterval, gwProtocolChanges, gwConnects,
+		gwSubBandUtilization, gwSubBandUtilizationLimit,
+		gwHeartbeats, gatewayClockAnomalies,
+		gwDownlinkAckLatency, gwDownlinkFailures, gwUplinksByApp,
+		gwGPSLocked, gwBatteryVolts, gwBatteryPercent, gwTemperature,
+		gwLocationInfo,
+	}
+	if gwInfo != nil {
+		vecs = append(vecs, gwInfo)
+	}
+	if gwFirmwareInfo.vec != nil {
+		vecs = append(vecs, gwFirmwareInfo.vec)
+	}
+	if legacyGwTime != nil {
+		vecs = append(vecs, legacyGwTime, legacyGwCount)
+	}
+
+	return vecs
+}
+
+// deleteGatewayMetrics removes all series of a gateway that is no longer
+// watched. The series of other gateways are left alone.
+func deleteGatewayMetrics(gwid string) {
+	label := relabelGateway(gwid)
+	for _, vec := range gatewayVecs() {
+		vec.DeletePartialMatch(prometheus.Labels{"gateway": label})
+	}
+
+	forgetLocation(label)
+	forgetFirmwareInfo(label)
+}
+
+// precreateSeries makes precreateGatewaySeries create the series of every
+// watched gateway, set with LYTGAE_PRECREATE_SERIES.
+var precreateSeries = false
+
+// precreateGatewaySeries creates the gateway_messages and
+// gateway_timestamp_seconds series of a gateway with a value of 0, so they
+// exist before its first connection stats and absent() works right away.
+// They are deleted again by deleteGatewayMetrics.
+func precreateGatewaySeries(gwid string) {
+	if !precreateSeries {
+		return
+	}
+
+	label := relabelGateway(gwid)
+	for _, typ := range []string{"connect", "uplink", "downlink", "txack"} {
+		if !metricTypeEnabled(gwid, typ) {
+			continue
+		}
+		gwTime.WithLabelValues(label, typ)
+		if legacyGwTime != nil {
+			legacyGwTime.WithLabelValues(label, typ)
+		}
+		if typ == "connect" {
+			continue
+		}
+		gwCount.WithLabelValues(label, typ)
+		if legacyGwCount != nil {
+			legacyGwCount.WithLabelValues(label, typ)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error kinds of the Client. Errors returned by the Client match them with
+// errors.Is, and errors.As gives the *ClientError with the gRPC status.
+var (
+	ErrUnauthenticated  = errors.New("unauthenticated")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrUnavailable      = errors.New("server unavailable")
+	ErrDiscovery        = errors.New("gateway discovery failed")
+)
+
+// ClientError is an error of a Client operation. Kinds holds the error
+// kinds it matches, Err the underlying error, usually a gRPC status.
+type ClientError struct {
+	Op    string
+	Kinds []error
+	Err   error
+}
+
+func (e *ClientError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *ClientError) Unwrap() []error {
+	return append([]error{e.Err}, e.Kinds...)
+}
+
+// GRPCStatus returns the status of the underlying error, so status.Code
+// still works on a ClientError.
+func (e *ClientError) GRPCStatus() *status.Status {
+	s, _ := status.FromError(e.Err)
+	return s
+}
+
+// newClientError wraps err, adding the kinds derived from its gRPC code to
+// the given ones.
+func newClientError(op string, err error, kinds ...error) error {
+	switch status.Code(err) {
+	case codes.Unauthenticated:
+		kinds = append(kinds, ErrUnauthenticated)
+	case codes.PermissionDenied:
+		kinds = append(kinds, ErrPermissionDenied)
+	case codes.Unavailable, codes.DeadlineExceeded:
+		kinds = append(kinds, ErrUnavailable)
+	}
+
+	return &ClientError{Op: op, Kinds: kinds, Err: err}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and timers, so that time dependent code
+// can be driven by fakeClock instead of the real time.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the part of time.Ticker used with a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{t: time.NewTicker(d)}
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (r realTicker) C() <-chan time.Time {
+	return r.t.C
+}
+
+func (r realTicker) Stop() {
+	r.t.Stop()
+}
+
+// fakeClock only moves forward when Advance is called, which fires all
+// timers and tickers that became due.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeTicker
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	return f.add(d, 0).c
+}
+
+func (f *fakeClock) NewTicker(d time.Duration) Ticker {
+	return f.add(d, d)
+}
+
+func (f *fakeClock) add(d, period time.Duration) *fakeTicker {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTicker{
+		clock:  f,
+		at:     f.now.Add(d),
+		period: period,
+		c:      make(chan time.Time, 1),
+	}
+	f.waiters = append(f.waiters, t)
+
+	return t
+}
+
+// Advance moves the clock forward by d. Like time.Ticker, a ticker whose
+// channel is still full drops the tick.
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+
+	waiters := f.waiters[:0]
+	for _, t := range f.waiters {
+		for !t.at.After(f.now) {
+			select {
+			case t.c <- t.at:
+			default:
+			}
+			if t.period == 0 {
+				break
+			}
+			t.at = t.at.Add(t.period)
+		}
+		if t.at.After(f.now) {
+			waiters = append(waiters, t)
+		}
+	}
+	f.waiters = waiters
+}
+
+type fakeTicker struct {
+	clock  *fakeClock
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	for i, w := range t.clock.waiters {
+		if w == t {
+			t.clock.waiters = append(t.clock.waiters[:i], t.clock.waiters[i+1:]...)
+			return
+		}
+	}
+}
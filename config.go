@@ -0,0 +1,848 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultServer = "eu1.cloud.thethings.network:8884"
+	defaultListen = ":2113"
+
+	// defaultGRPCMaxRecv is larger than the gRPC default of 4 MiB, which
+	// big connection stats messages can exceed.
+	defaultGRPCMaxRecv = 16 << 20
+
+	defaultMaxGateways = 10000
+
+	modeStream = "stream"
+	modePoll   = "poll"
+)
+
+// APIKey is an API key that is used for all gateways matching Pattern.
+type APIKey struct {
+	Pattern string
+	Key     string
+}
+
+type Config struct {
+	// Server is the primary server, the first entry of Servers. The
+	// others are failed over to in order.
+	Server   string
+	Servers  []string
+	APIKey   string
+	Gateways []string
+	// GatewayFile is the file Gateways were read from, if any.
+	GatewayFile string
+	// GatewayCache is a file the discovered gateways are written to, and
+	// read from if the discovery fails at startup.
+	GatewayCache string
+
+	// FailoverAfter is the number of consecutive failed connects after
+	// which the next server is tried.
+	FailoverAfter int
+
+	// APIKeys holds the entries of LYTGAE_KEYS followed by LYTGAE_APIKEY
+	// for all remaining gateways. A separate stream is opened per key.
+	APIKeys []APIKey
+
+	Applications     []string
+	AppGatewayWindow time.Duration
+	// AppUplinksAllow and AppUplinksMax enable uplink counts per gateway
+	// and application, for the listed applications or the first
+	// AppUplinksMax ones.
+	AppUplinksAllow []string
+	AppUplinksMax   int
+	// DownlinkAckTimeout is how long a sent downlink waits for its ack
+	// before it is discarded.
+	DownlinkAckTimeout time.Duration
+
+	// Mode is modeStream to subscribe to events or modePoll to fetch the
+	// connection stats every PollInterval, for API keys that may not
+	// stream events.
+	Mode         string
+	PollInterval time.Duration
+
+	Events []string
+	// StreamNames are the event names requested from the server. Nil
+	// means all events.
+	StreamNames []string
+
+	GatewayRelabel string
+	// GatewayGroups and GroupTypes select the metric types exported per
+	// group of gateways, see setGatewayGroups.
+	GatewayGroups string
+	GroupTypes    string
+	// GatewayAttributes are the registry attributes exported as labels
+	// of gateway_info.
+	GatewayAttributes []string
+	// FirmwareInfoKeys are the status versions exported as labels of
+	// gateway_firmware_info, which is disabled if empty.
+	FirmwareInfoKeys []string
+
+	// KeepaliveTime is the interval of the client keepalive pings. Servers
+	// enforce a minimum interval (grpc-go servers default to 5m without
+	// active streams) and close the connection with GOAWAY
+	// "too_many_pings" if the client pings more often than allowed, so
+	// lowering this can cause reconnects instead of preventing them.
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
+	// Plaintext connects without TLS, for local development servers.
+	Plaintext bool
+	// ConnMaxAge is the age after which the connection to the server is
+	// replaced with a new one, zero keeps it forever.
+	ConnMaxAge time.Duration
+
+	// GRPCProxy is an HTTP proxy the gRPC connection is tunneled through.
+	GRPCProxy string
+
+	// StartupJitter is the maximum random delay before the first API
+	// request.
+	StartupJitter time.Duration
+
+	// RetryMaxAttempts is the number of attempts of unary calls and stream
+	// setups, 1 disables retries.
+	RetryMaxAttempts int
+
+	InitialSync       bool
+	StreamIdleTimeout time.Duration
+	GRPCMaxRecv       int
+	// RequireGateways makes startup fail if no API key has gateways to
+	// watch, otherwise only a warning is logged.
+	RequireGateways bool
+
+	// StreamTail and StreamAfter request past events when the stream is
+	// opened: the last StreamTail events and the events since StreamAfter.
+	// Reconnects ask for the events since the last received one instead of
+	// StreamAfter. The server only replays what its event store still
+	// holds, by default the last 100 events per entity of the last 10
+	// minutes, and does not replay at all without an event store.
+	StreamTail  uint32
+	StreamAfter time.Time
+
+	// BatteryVoltsKeys, BatteryPercentKeys and TemperatureKeys replace the
+	// status metric keys the metrics are read from, if set.
+	BatteryVoltsKeys   []string
+	BatteryPercentKeys []string
+	TemperatureKeys    []string
+
+	UplinkPayloadMetric bool
+	FleetMetrics        bool
+	// BandwidthMetrics exports the payload bytes per gateway, summed up
+	// from the uplink and downlink events.
+	BandwidthMetrics bool
+	// DownlinkMetrics subscribes to the downlink events, for the ack
+	// latency and the downlink failures.
+	DownlinkMetrics bool
+	// LegacyMetricNames additionally exports metrics that were renamed
+	// under their old names.
+	LegacyMetricNames bool
+	// DisableDefaultCollectors removes the Go runtime and process metrics
+	// of the client library.
+	DisableDefaultCollectors bool
+	// PrecreateSeries creates the message count and timestamp series of
+	// all watched gateways at startup, with a value of 0.
+	PrecreateSeries bool
+	// MaxLabelLength truncates label values from gateway attributes and
+	// status versions.
+	MaxLabelLength int
+
+	Listen       []string
+	SelfTest     bool
+	MetricsToken string
+	TLSCert      string
+	TLSKey       string
+	// ConfigEndpoint controls who can read /config: local, any or off.
+	ConfigEndpoint string
+	// MuteEndpoint controls who can mute gateways through /gateways/, with
+	// the same values as ConfigEndpoint.
+	MuteEndpoint string
+	// OpenMetrics serves the OpenMetrics format to scrapers asking for it,
+	// with exemplars linking some metrics to the events behind them.
+	OpenMetrics bool
+
+	// PushgatewayURL enables pushing the metrics to a Pushgateway every
+	// PushInterval, in addition to serving them.
+	PushgatewayURL string
+	PushInterval   time.Duration
+	// InfluxURL enables writing the gateways to this InfluxDB write
+	// endpoint every InfluxInterval, in at most InfluxBatchSize points per
+	// request.
+	InfluxURL       string
+	InfluxToken     string
+	InfluxInterval  time.Duration
+	InfluxBatchSize int
+	// StatsdAddr enables sending the gateways to this StatsD server over
+	// UDP every StatsdInterval. StatsdName is the name template of the
+	// metrics, see statsdName.
+	StatsdAddr     string
+	StatsdInterval time.Duration
+	StatsdName     string
+
+	Store         string
+	RedisAddr     string
+	RedisPassword string
+	RedisKey      string
+
+	DuplicateWindow time.Duration
+	// MaxGateways limits the number of tracked gateways, zero means no
+	// limit.
+	MaxGateways int
+
+	// Instance tells replicas apart, it defaults to the hostname.
+	Instance string
+	// Namespace is prefixed to the metric names by the Prometheus configs
+	// generated by the scrape-config and rules commands.
+	Namespace string
+
+	SentryDSN string
+	Debug     bool
+	// ClampTimestamps replaces gateway timestamps in the future with the
+	// current time.
+	ClampTimestamps bool
+	// SNRSmoothing is the weight of the latest uplink in the SNR average
+	// of a gateway.
+	SNRSmoothing float64
+	// GatewayLogMinInterval is the minimum time between two messages
+	// logged about a gateway, zero logs all of them.
+	GatewayLogMinInterval time.Duration
+	// LogSummaryInterval is the interval of the gateway table in the log,
+	// zero disables it.
+	LogSummaryInterval time.Duration
+
+	// RecordFile receives every streamed event as a JSON line. It is
+	// rotated once it grows beyond RecordMaxSize bytes, zero disables the
+	// rotation.
+	RecordFile    string
+	RecordMaxSize int
+	// ReplayFile replaces the live streams with the events recorded in
+	// it. ReplaySpeed divides the time between two events, zero replays
+	// them without waiting.
+	ReplayFile  string
+	ReplaySpeed float64
+
+	// RulesNoUplink and RulesDutyCycle are the thresholds of the alerting
+	// rules printed by the rules command. They are also exported as
+	// lytgae_threshold_* gauges for dashboards.
+	RulesNoUplink  time.Duration
+	RulesDutyCycle float64
+}
+
+func configFromEnv() (*Config, error) {
+	cfg := &Config{}
+
+	keys, _, err := envSecret("LYTGAE_KEYS")
+	if err != nil {
+		return nil, err
+	}
+	cfg.APIKeys, err = parseAPIKeys(keys)
+	if err != nil {
+		return nil, fmt.Errorf("LYTGAE_KEYS: %v", err)
+	}
+
+	apikey, ok, err := envSecret("LYTGAE_APIKEY")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		cfg.APIKey = apikey
+		cfg.APIKeys = append(cfg.APIKeys, APIKey{Pattern: "*", Key: apikey})
+	}
+
+	// Every address serves the same endpoints.
+	listen := os.Getenv("LYTGAE_LISTEN")
+	if listen == "" {
+		listen = defaultListen
+	}
+	cfg.Listen = strings.Split(listen, ",")
+
+	cfg.Store = os.Getenv("LYTGAE_STORE")
+	cfg.RedisAddr = os.Getenv("LYTGAE_REDIS_ADDR")
+	cfg.RedisPassword, _, err = envSecret("LYTGAE_REDIS_PASSWORD")
+	if err != nil {
+		return nil, err
+	}
+	cfg.RedisKey = os.Getenv("LYTGAE_REDIS_KEY")
+	if cfg.RedisKey == "" {
+		cfg.RedisKey = "lytgae:gateways"
+	}
+
+	cfg.DuplicateWindow, err = envDuration("LYTGAE_DUPLICATE_WINDOW", 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.MaxGateways, err = envInt("LYTGAE_MAX_GATEWAYS", defaultMaxGateways)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxGateways < 0 {
+		return nil, fmt.Errorf("LYTGAE_MAX_GATEWAYS must not be negative")
+	}
+
+	cfg.SentryDSN, _, err = envSecret("LYTGAE_SENTRY_DSN")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Debug, err = envBool("LYTGAE_DEBUG", false)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Instance = os.Getenv("LYTGAE_INSTANCE")
+	if cfg.Instance == "" {
+		cfg.Instance, err = os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("LYTGAE_INSTANCE is not set and the hostname is unknown: %v", err)
+		}
+	}
+
+	cfg.Namespace = os.Getenv("LYTGAE_NAMESPACE")
+	if cfg.Namespace != "" && !labelNameRe.MatchString(cfg.Namespace) {
+		return nil, fmt.Errorf("LYTGAE_NAMESPACE %q is not a valid metric name prefix", cfg.Namespace)
+	}
+
+	cfg.ClampTimestamps, err = envBool("LYTGAE_CLAMP_TIMESTAMPS", true)
+	if err != nil {
+		return nil, err
+	}
+
+	if ev, ok := os.LookupEnv("LYTGAE_SNR_SMOOTHING"); ok {
+		cfg.SNRSmoothing, err = strconv.ParseFloat(ev, 64)
+		if err != nil {
+			return nil, fmt.Errorf("LYTGAE_SNR_SMOOTHING: %v", err)
+		}
+		if cfg.SNRSmoothing <= 0 || cfg.SNRSmoothing > 1 {
+			return nil, fmt.Errorf("LYTGAE_SNR_SMOOTHING must be in (0, 1]")
+		}
+	} else {
+		cfg.SNRSmoothing = 0.1
+	}
+
+	cfg.GatewayLogMinInterval, err = envDuration("LYTGAE_GW_LOG_MIN_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.GatewayLogMinInterval < 0 {
+		return nil, fmt.Errorf("LYTGAE_GW_LOG_MIN_INTERVAL must not be negative")
+	}
+
+	cfg.LogSummaryInterval, err = envDuration("LYTGAE_LOG_SUMMARY_INTERVAL", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.RecordFile = os.Getenv("LYTGAE_RECORD_FILE")
+	cfg.RecordMaxSize, err = envInt("LYTGAE_RECORD_MAX_SIZE", 100<<20)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RecordMaxSize < 0 {
+		return nil, fmt.Errorf("LYTGAE_RECORD_MAX_SIZE must not be negative")
+	}
+	cfg.ReplayFile = os.Getenv("LYTGAE_REPLAY_FILE")
+	if ev, ok := os.LookupEnv("LYTGAE_REPLAY_SPEED"); ok {
+		cfg.ReplaySpeed, err = strconv.ParseFloat(ev, 64)
+		if err != nil {
+			return nil, fmt.Errorf("LYTGAE_REPLAY_SPEED: %v", err)
+		}
+		if cfg.ReplaySpeed < 0 {
+			return nil, fmt.Errorf("LYTGAE_REPLAY_SPEED must not be negative")
+		}
+	} else {
+		cfg.ReplaySpeed = 1
+	}
+	if cfg.ReplayFile != "" && cfg.RecordFile != "" {
+		return nil, fmt.Errorf("LYTGAE_RECORD_FILE and LYTGAE_REPLAY_FILE are mutually exclusive")
+	}
+
+	server, ok := os.LookupEnv("LYTGAE_SERVER")
+	if !ok {
+		log.Printf("LYTGAE_SERVER is not set, fallback to %s", defaultServer)
+		server = defaultServer
+	}
+	cfg.Servers = strings.Split(server, ",")
+	cfg.Server = cfg.Servers[0]
+
+	cfg.FailoverAfter, err = envInt("LYTGAE_FAILOVER_AFTER", 3)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.FailoverAfter <= 0 {
+		return nil, fmt.Errorf("LYTGAE_FAILOVER_AFTER must be positive")
+	}
+
+	egws, ok := os.LookupEnv("LYTGAE_GW")
+	if ok {
+		cfg.Gateways = strings.Split(egws, ",")
+	}
+	if file, fok := os.LookupEnv("LYTGAE_GW_FILE"); fok {
+		if ok {
+			return nil, fmt.Errorf("only one of LYTGAE_GW and LYTGAE_GW_FILE may be set")
+		}
+		cfg.GatewayFile = file
+		cfg.Gateways, err = readGatewayFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("LYTGAE_GW_FILE: %v", err)
+		}
+	}
+
+	cfg.GatewayCache = os.Getenv("LYTGAE_GATEWAY_CACHE")
+
+	if eapps, ok := os.LookupEnv("LYTGAE_APPLICATIONS"); ok {
+		cfg.Applications = strings.Split(eapps, ",")
+	}
+	if eallow, ok := os.LookupEnv("LYTGAE_APP_UPLINKS_ALLOW"); ok && eallow != "" {
+		cfg.AppUplinksAllow = strings.Split(eallow, ",")
+	}
+	cfg.AppUplinksMax, err = envInt("LYTGAE_APP_UPLINKS_MAX", 0)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AppUplinksMax < 0 {
+		return nil, fmt.Errorf("LYTGAE_APP_UPLINKS_MAX must not be negative")
+	}
+
+	cfg.MetricsToken, _, err = envSecret("LYTGAE_METRICS_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.TLSCert = os.Getenv("LYTGAE_TLS_CERT")
+	cfg.TLSKey = os.Getenv("LYTGAE_TLS_KEY")
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return nil, fmt.Errorf("LYTGAE_TLS_CERT and LYTGAE_TLS_KEY must be set together")
+	}
+
+	cfg.PushgatewayURL = os.Getenv("LYTGAE_PUSHGATEWAY_URL")
+	cfg.PushInterval, err = envDuration("LYTGAE_PUSH_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PushInterval <= 0 {
+		return nil, fmt.Errorf("LYTGAE_PUSH_INTERVAL must be positive")
+	}
+
+	cfg.InfluxURL = os.Getenv("LYTGAE_INFLUX_URL")
+	cfg.InfluxToken, _, err = envSecret("LYTGAE_INFLUX_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	cfg.InfluxInterval, err = envDuration("LYTGAE_INFLUX_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.InfluxInterval <= 0 {
+		return nil, fmt.Errorf("LYTGAE_INFLUX_INTERVAL must be positive")
+	}
+	cfg.InfluxBatchSize, err = envInt("LYTGAE_INFLUX_BATCH_SIZE", 5000)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.InfluxBatchSize <= 0 {
+		return nil, fmt.Errorf("LYTGAE_INFLUX_BATCH_SIZE must be positive")
+	}
+
+	cfg.StatsdAddr = os.Getenv("LYTGAE_STATSD_ADDR")
+	cfg.StatsdInterval, err = envDuration("LYTGAE_STATSD_INTERVAL", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.StatsdInterval <= 0 {
+		return nil, fmt.Errorf("LYTGAE_STATSD_INTERVAL must be positive")
+	}
+	cfg.StatsdName = os.Getenv("LYTGAE_STATSD_NAME")
+	if cfg.StatsdName == "" {
+		cfg.StatsdName = defaultStatsdName
+	}
+	if !strings.Contains(cfg.StatsdName, "{gateway}") || !strings.Contains(cfg.StatsdName, "{metric}") {
+		return nil, fmt.Errorf("LYTGAE_STATSD_NAME must contain {gateway} and {metric}")
+	}
+
+	cfg.ConfigEndpoint = configEndpointLocal
+	if ev, ok := os.LookupEnv("LYTGAE_CONFIG_ENDPOINT"); ok {
+		cfg.ConfigEndpoint = ev
+	}
+	switch cfg.ConfigEndpoint {
+	case configEndpointLocal, configEndpointAny, configEndpointOff:
+	default:
+		return nil, fmt.Errorf("LYTGAE_CONFIG_ENDPOINT must be %s, %s or %s", configEndpointLocal, configEndpointAny, configEndpointOff)
+	}
+
+	cfg.MuteEndpoint = configEndpointLocal
+	if ev, ok := os.LookupEnv("LYTGAE_MUTE_ENDPOINT"); ok {
+		cfg.MuteEndpoint = ev
+	}
+	switch cfg.MuteEndpoint {
+	case configEndpointLocal, configEndpointAny, configEndpointOff:
+	default:
+		return nil, fmt.Errorf("LYTGAE_MUTE_ENDPOINT must be %s, %s or %s", configEndpointLocal, configEndpointAny, configEndpointOff)
+	}
+
+	cfg.OpenMetrics, err = envBool("LYTGAE_OPENMETRICS", false)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.SelfTest, err = envBool("LYTGAE_SELFTEST", false)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.UplinkPayloadMetric, err = envBool("LYTGAE_UPLINK_PAYLOAD_METRIC", false)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.BandwidthMetrics, err = envBool("LYTGAE_BANDWIDTH_METRICS", false)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.DownlinkMetrics, err = envBool("LYTGAE_DOWNLINK_METRICS", false)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.FleetMetrics, err = envBool("LYTGAE_FLEET_METRICS", false)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.LegacyMetricNames, err = envBool("LYTGAE_LEGACY_METRIC_NAMES", false)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.DisableDefaultCollectors, err = envBool("LYTGAE_DISABLE_DEFAULT_COLLECTORS", false)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.PrecreateSeries, err = envBool("LYTGAE_PRECREATE_SERIES", false)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.MaxLabelLength, err = envInt("LYTGAE_MAX_LABEL_LENGTH", 128)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxLabelLength <= 0 {
+		return nil, fmt.Errorf("LYTGAE_MAX_LABEL_LENGTH must be positive")
+	}
+
+	cfg.DownlinkAckTimeout, err = envDuration("LYTGAE_DOWNLINK_ACK_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DownlinkAckTimeout <= 0 {
+		return nil, fmt.Errorf("LYTGAE_DOWNLINK_ACK_TIMEOUT must be positive")
+	}
+
+	cfg.AppGatewayWindow, err = envDuration("LYTGAE_APP_GATEWAY_WINDOW", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	if ekeys, ok := os.LookupEnv("LYTGAE_BATTERY_VOLTS_KEYS"); ok {
+		cfg.BatteryVoltsKeys = strings.Split(ekeys, ",")
+	}
+	if ekeys, ok := os.LookupEnv("LYTGAE_BATTERY_PERCENT_KEYS"); ok {
+		cfg.BatteryPercentKeys = strings.Split(ekeys, ",")
+	}
+	if ekeys, ok := os.LookupEnv("LYTGAE_TEMPERATURE_KEYS"); ok {
+		cfg.TemperatureKeys = strings.Split(ekeys, ",")
+	}
+
+	cfg.GatewayRelabel = os.Getenv("LYTGAE_GW_RELABEL")
+	cfg.GatewayGroups = os.Getenv("LYTGAE_GW_GROUPS")
+	cfg.GroupTypes = os.Getenv("LYTGAE_GROUP_TYPES")
+	if eattrs, ok := os.LookupEnv("LYTGAE_GW_ATTRIBUTES"); ok {
+		cfg.GatewayAttributes = strings.Split(eattrs, ",")
+	}
+	if ekeys, ok := os.LookupEnv("LYTGAE_FIRMWARE_INFO_KEYS"); ok && ekeys != "" {
+		cfg.FirmwareInfoKeys = strings.Split(ekeys, ",")
+	}
+
+	if eevs, ok := os.LookupEnv("LYTGAE_EVENTS"); ok {
+		cfg.Events = strings.Split(eevs, ",")
+	} else {
+		cfg.Events = []string{evConnectionStats}
+		if cfg.UplinkPayloadMetric || cfg.BandwidthMetrics {
+			cfg.Events = append(cfg.Events, evGatewayUplink)
+		}
+		if cfg.BandwidthMetrics || cfg.DownlinkMetrics {
+			cfg.Events = append(cfg.Events, evDownlinkSend)
+		}
+		if cfg.DownlinkMetrics {
+			cfg.Events = append(cfg.Events, evDownlinkTxSuccess, evDownlinkTxFail, evDownlinkScheduleFail)
+		}
+		if len(cfg.Applications) > 0 {
+			cfg.Events = append(cfg.Events, evApplicationUp)
+		}
+	}
+
+	// Only request the events we handle, unless overridden. "*" disables
+	// the server side filter.
+	cfg.StreamNames = make([]string, len(cfg.Events))
+	for i, name := range cfg.Events {
+		cfg.StreamNames[i] = streamName(name)
+	}
+	if enames, ok := os.LookupEnv("LYTGAE_STREAM_NAMES"); ok {
+		cfg.StreamNames = strings.Split(enames, ",")
+		if enames == "*" {
+			cfg.StreamNames = nil
+		}
+	}
+
+	cfg.KeepaliveTime, err = envDuration("LYTGAE_KEEPALIVE_TIME", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	// grpc-go silently raises anything below 10s to 10s.
+	if cfg.KeepaliveTime < 10*time.Second {
+		return nil, fmt.Errorf("LYTGAE_KEEPALIVE_TIME must be at least 10s")
+	}
+	cfg.KeepaliveTimeout, err = envDuration("LYTGAE_KEEPALIVE_TIMEOUT", time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.KeepaliveTimeout <= 0 || cfg.KeepaliveTimeout >= cfg.KeepaliveTime {
+		return nil, fmt.Errorf("LYTGAE_KEEPALIVE_TIMEOUT must be positive and less than LYTGAE_KEEPALIVE_TIME")
+	}
+
+	cfg.Plaintext, err = envBool("LYTGAE_PLAINTEXT", false)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.ConnMaxAge, err = envDuration("LYTGAE_CONN_MAX_AGE", 0)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ConnMaxAge < 0 {
+		return nil, fmt.Errorf("LYTGAE_CONN_MAX_AGE must not be negative")
+	}
+
+	cfg.GRPCProxy, _, err = envSecret("LYTGAE_GRPC_PROXY")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Mode = os.Getenv("LYTGAE_MODE")
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = modeStream
+	case modeStream, modePoll:
+	default:
+		return nil, fmt.Errorf("LYTGAE_MODE must be %s or %s", modeStream, modePoll)
+	}
+	cfg.PollInterval, err = envDuration("LYTGAE_POLL_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PollInterval <= 0 {
+		return nil, fmt.Errorf("LYTGAE_POLL_INTERVAL must be positive")
+	}
+	if cfg.Mode == modePoll && len(cfg.Applications) > 0 {
+		log.Printf("LYTGAE_APPLICATIONS needs the event stream, it is ignored in poll mode")
+	}
+
+	cfg.StartupJitter, err = envDuration("LYTGAE_STARTUP_JITTER", 0)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.StartupJitter < 0 {
+		return nil, fmt.Errorf("LYTGAE_STARTUP_JITTER must not be negative")
+	}
+
+	tail, err := envInt("LYTGAE_TAIL", 0)
+	if err != nil {
+		return nil, err
+	}
+	if tail < 0 || tail > math.MaxUint32 {
+		return nil, fmt.Errorf("LYTGAE_TAIL is out of range")
+	}
+	cfg.StreamTail = uint32(tail)
+
+	if ev, ok := os.LookupEnv("LYTGAE_AFTER"); ok {
+		cfg.StreamAfter, err = time.Parse(time.RFC3339, ev)
+		if err != nil {
+			return nil, fmt.Errorf("LYTGAE_AFTER: %v", err)
+		}
+	}
+
+	cfg.RetryMaxAttempts, err = envInt("LYTGAE_RETRY_MAX_ATTEMPTS", 3)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RetryMaxAttempts < 1 {
+		return nil, fmt.Errorf("LYTGAE_RETRY_MAX_ATTEMPTS must be at least 1")
+	}
+
+	cfg.InitialSync, err = envBool("LYTGAE_INITIAL_SYNC", true)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.RequireGateways, err = envBool("LYTGAE_REQUIRE_GATEWAYS", true)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.StreamIdleTimeout, err = envDuration("LYTGAE_STREAM_IDLE_TIMEOUT", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.GRPCMaxRecv, err = envInt("LYTGAE_GRPC_MAX_RECV", defaultGRPCMaxRecv)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.GRPCMaxRecv <= 0 {
+		return nil, fmt.Errorf("LYTGAE_GRPC_MAX_RECV must be positive")
+	}
+
+	cfg.RulesNoUplink, err = envDuration("LYTGAE_RULES_NO_UPLINK", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if ev, ok := os.LookupEnv("LYTGAE_RULES_DUTY_CYCLE"); ok {
+		cfg.RulesDutyCycle, err = strconv.ParseFloat(ev, 64)
+		if err != nil {
+			return nil, fmt.Errorf("LYTGAE_RULES_DUTY_CYCLE: %v", err)
+		}
+	} else {
+		cfg.RulesDutyCycle = 0.9
+	}
+
+	return cfg, nil
+}
+
+// readGatewayFile reads gateway IDs from a file with one ID per line. Blank
+// lines and lines starting with # are ignored. A file without any ID is an
+// error, as an empty gateway list would subscribe to all gateways instead.
+func readGatewayFile(name string) ([]string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var rtn []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rtn = append(rtn, line)
+	}
+	if len(rtn) == 0 {
+		return nil, fmt.Errorf("%s contains no gateway IDs", name)
+	}
+
+	return rtn, nil
+}
+
+// parseAPIKeys parses a comma separated list of pattern=key entries. The
+// patterns use path.Match syntax and are matched against gateway IDs.
+func parseAPIKeys(s string) ([]APIKey, error) {
+	var rtn []APIKey
+	if s == "" {
+		return rtn, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		pattern, key, ok := strings.Cut(entry, "=")
+		if !ok || pattern == "" || key == "" {
+			return nil, fmt.Errorf("invalid entry, expected pattern=key")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s: %v", pattern, err)
+		}
+		rtn = append(rtn, APIKey{Pattern: pattern, Key: key})
+	}
+
+	return rtn, nil
+}
+
+// keyIndex returns the index of the first entry in APIKeys whose pattern
+// matches the gateway ID, or -1 if there is none.
+func (cfg *Config) keyIndex(gwid string) int {
+	for i, k := range cfg.APIKeys {
+		if ok, _ := path.Match(k.Pattern, gwid); ok {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// envSecret looks up name or, if that is not set, reads the value from the
+// file named by name_FILE. This allows secrets to be mounted as files.
+func envSecret(name string) (string, bool, error) {
+	v, ok := os.LookupEnv(name)
+	file, fok := os.LookupEnv(name + "_FILE")
+	if ok && fok {
+		return "", false, fmt.Errorf("only one of %s and %s_FILE may be set", name, name)
+	}
+	if !fok {
+		return v, ok, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %v", name, err)
+	}
+
+	return strings.TrimSpace(string(b)), true, nil
+}
+
+func envBool(name string, fallback bool) (bool, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", name, err)
+	}
+
+	return b, nil
+}
+
+func envInt(name string, fallback int) (int, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", name, err)
+	}
+
+	return i, nil
+}
+
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", name, err)
+	}
+
+	return d, nil
+}
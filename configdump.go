@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const (
+	configEndpointLocal = "local"
+	configEndpointAny   = "any"
+	configEndpointOff   = "off"
+)
+
+const redactedValue = "***"
+
+// redacted returns a copy of cfg with all secrets replaced. Every new field
+// holding a secret has to be added here.
+func (cfg *Config) redacted() *Config {
+	r := *cfg
+
+	r.APIKey = redactAPIKey(r.APIKey)
+	r.APIKeys = slices.Clone(r.APIKeys)
+	for i := range r.APIKeys {
+		r.APIKeys[i].Key = redactAPIKey(r.APIKeys[i].Key)
+	}
+	r.MetricsToken = redactString(r.MetricsToken)
+	r.RedisPassword = redactString(r.RedisPassword)
+	r.InfluxToken = redactString(r.InfluxToken)
+
+	// These URLs may carry credentials, the Sentry DSN always does.
+	r.SentryDSN = redactURL(r.SentryDSN)
+	r.GRPCProxy = redactURL(r.GRPCProxy)
+	r.PushgatewayURL = redactURL(r.PushgatewayURL)
+	r.InfluxURL = redactURL(r.InfluxURL)
+
+	return &r
+}
+
+// redactAPIKey keeps the type prefix of a Things Stack API key, like
+// "NNSXS.", which helps telling keys apart from other tokens.
+func redactAPIKey(key string) string {
+	if prefix, _, ok := strings.Cut(key, "."); ok {
+		return prefix + "." + redactedValue
+	}
+
+	return redactString(key)
+}
+
+func redactString(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	return redactedValue
+}
+
+// redactURL replaces the user info and the query of a URL. Values that do
+// not parse are replaced completely.
+func redactURL(s string) string {
+	if s == "" {
+		return ""
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return redactedValue
+	}
+
+	// url.User would escape the asterisks.
+	user := u.User != nil
+	u.User = nil
+	if u.RawQuery != "" {
+		u.RawQuery = redactedValue
+	}
+	rtn := u.String()
+	if user {
+		rtn = strings.Replace(rtn, "://", "://"+redactedValue+"@", 1)
+	}
+
+	return rtn
+}
+
+// configHandler serves the configuration with the secrets redacted. With
+// access set to configEndpointLocal, only clients on the loopback interface
+// are served.
+func configHandler(cfg *Config, access string) http.Handler {
+	body, err := json.MarshalIndent(cfg.redacted(), "", "  ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if access == configEndpointLocal && !isLoopback(r.RemoteAddr) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+	"google.golang.org/grpc"
+)
+
+// recycleOverlapEvents is the number of recent events whose IDs are kept to
+// drop the duplicates at the start of a recycled stream.
+const recycleOverlapEvents = 1024
+
+var (
+	connRecycles = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "lytgae_conn_recycles_total",
+		Help: "Number of gRPC connections replaced because they reached LYTGAE_CONN_MAX_AGE.",
+	})
+	recycleDuplicates = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "lytgae_conn_recycle_duplicates_total",
+		Help: "Number of events dropped because they were received on both streams while replacing a connection.",
+	})
+)
+
+// recycledStream is an event stream on a new connection to servers[server],
+// waiting to replace the current one.
+type recycledStream struct {
+	server int
+	conn   *grpc.ClientConn
+	esc    ttnpb.Events_StreamClient
+	cancel context.CancelFunc
+	req    *ttnpb.StreamEventsRequest
+}
+
+// recycleConns replaces the connection in use every connMaxAge until done
+// is closed. The stream on the new connection is opened before the current
+// one is stopped, so no events are missed. The events received on both
+// streams in between are dropped by getEvents, see recentEvents.
+func (c *Client) recycleConns(done <-chan struct{}) {
+	t := c.clock.NewTicker(c.connMaxAge)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C():
+		}
+
+		rs, err := c.openRecycled()
+		if err != nil {
+			log.Printf("key %s: new connection: %v, keeping the current one", c.name, err)
+			continue
+		}
+
+		select {
+		case c.recycled <- rs:
+			c.stopStream()
+		default:
+			rs.cancel()
+			rs.conn.Close()
+		}
+	}
+}
+
+func (c *Client) openRecycled() (*recycledStream, error) {
+	c.mu.Lock()
+	server := c.current
+	c.mu.Unlock()
+
+	conn, err := c.dial(c.servers[server])
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	req := c.streamRequest()
+	esc, err := ttnpb.NewEventsClient(conn).Stream(ctx, req, c.streamCallOptions()...)
+	if err != nil {
+		cancel()
+		conn.Close()
+		return nil, newClientError("stream", err)
+	}
+
+	return &recycledStream{server: server, conn: conn, esc: esc, cancel: cancel, req: req}, nil
+}
+
+// useRecycled switches getEvents to the stream on the new connection and
+// closes the old connection. It reports false if the client failed over to
+// another server in the meantime, then the stream is discarded.
+func (c *Client) useRecycled(rs *recycledStream) bool {
+	c.mu.Lock()
+	if rs.server != c.current {
+		c.mu.Unlock()
+		rs.cancel()
+		rs.conn.Close()
+		return false
+	}
+	old := c.conns[rs.server]
+	c.conns[rs.server] = rs.conn
+	c.conn = rs.conn
+	c.cancelStream = rs.cancel
+	c.connected = c.clock.Now()
+	c.mu.Unlock()
+
+	c.esc = &rs.esc
+	c.streamHeader = true
+	c.setActive(true)
+	gatewaysSubscribed.WithLabelValues(c.name).Set(float64(countGateways(rs.req.Identifiers)))
+	streamIdentifiers.WithLabelValues(c.name).Set(float64(len(rs.req.Identifiers)))
+	connRecycles.Inc()
+	debugf("key %s: replaced the connection to %s", c.name, c.server)
+
+	// The old stream was already stopped, nothing is left to drain.
+	old.Close()
+
+	return true
+}
+
+// recentEvents holds the unique IDs of the last events of the streams of a
+// client. The new stream of a recycled connection starts with the events
+// the old one received after the new one was opened, so after a switch
+// events are duplicates up to the first one that is not among them.
+type recentEvents struct {
+	ids  []string
+	next int
+	seen map[string]bool
+	// overlap is set by a switch to a recycled stream.
+	overlap bool
+}
+
+func newRecentEvents(n int) *recentEvents {
+	return &recentEvents{
+		ids:  make([]string, n),
+		seen: make(map[string]bool, n),
+	}
+}
+
+// duplicate reports whether the event with the unique ID id was received on
+// the old stream already. Otherwise the ID is remembered, forgetting the
+// oldest one if needed.
+func (r *recentEvents) duplicate(id string) bool {
+	if r.overlap && id != "" && r.seen[id] {
+		return true
+	}
+	r.overlap = false
+
+	if id == "" || r.seen[id] {
+		return false
+	}
+	delete(r.seen, r.ids[r.next])
+	r.ids[r.next] = id
+	r.seen[id] = true
+	r.next = (r.next + 1) % len(r.ids)
+
+	return false
+}
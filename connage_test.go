@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestRecentEventsDuplicate(t *testing.T) {
+	r := newRecentEvents(3)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		if r.duplicate(id) {
+			t.Fatalf("%s is a duplicate without a recycled stream", id)
+		}
+	}
+
+	// The new stream repeats c and d, e is the first new event. "a" was
+	// forgotten already.
+	r.overlap = true
+	for _, tc := range []struct {
+		id   string
+		want bool
+	}{
+		{id: "c", want: true},
+		{id: "d", want: true},
+		{id: "", want: false},
+		{id: "c", want: false},
+		{id: "e", want: false},
+	} {
+		if got := r.duplicate(tc.id); got != tc.want {
+			t.Errorf("duplicate(%q) = %t, want %t", tc.id, got, tc.want)
+		}
+	}
+
+	r.overlap = true
+	if r.duplicate("a") {
+		t.Errorf("duplicate(a) = true, want the oldest ID forgotten")
+	}
+	if r.duplicate("e") {
+		t.Errorf("duplicate(e) = true after the overlap ended")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.thethings.network/lorawan-stack/v3/pkg/events"
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+)
+
+const (
+	evDownlinkSend         = "gs.down.send"
+	evDownlinkTxSuccess    = "gs.down.tx.success"
+	evDownlinkTxFail       = "gs.down.tx.fail"
+	evDownlinkScheduleFail = "gs.down.schedule.fail"
+
+	// maxPendingDownlinks bounds the downlinks waiting for their ack.
+	maxPendingDownlinks = 10000
+)
+
+var (
+	gwDownlinkAckLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "gateway_downlink_ack_latency_seconds",
+		Help: "Time from sending a downlink to the gateway until it acknowledged the transmission.",
+		// 50ms to 25.6s, class B and C downlinks can be scheduled seconds ahead.
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"gateway"})
+	gwDownlinkFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_downlink_failures_total",
+		Help: "Number of downlinks that could not be scheduled on or transmitted by the gateway, by reason.",
+	}, []string{"gateway", "reason"})
+	pendingDownlinksDiscarded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lytgae_pending_downlinks_discarded_total",
+		Help: "Number of sent downlinks that were not matched with an ack, by reason.",
+	}, []string{"reason"})
+)
+
+// txFailureReasons are the label values of gateway_downlink_failures_total
+// for the tx ack results. Unknown results are counted as "unknown".
+var txFailureReasons = map[ttnpb.TxAcknowledgment_Result]string{
+	ttnpb.TxAcknowledgment_TOO_LATE:         "too_late",
+	ttnpb.TxAcknowledgment_TOO_EARLY:        "too_early",
+	ttnpb.TxAcknowledgment_COLLISION_PACKET: "collision_packet",
+	ttnpb.TxAcknowledgment_COLLISION_BEACON: "collision_beacon",
+	ttnpb.TxAcknowledgment_TX_FREQ:          "tx_freq",
+	ttnpb.TxAcknowledgment_TX_POWER:         "tx_power",
+	ttnpb.TxAcknowledgment_GPS_UNLOCKED:     "gps_unlocked",
+}
+
+// handleDownlinkFailure counts the downlinks a gateway could not transmit.
+// The server sends the tx ack result as event data.
+func handleDownlinkFailure(ev events.Event) {
+	var result ttnpb.TxAcknowledgment_Result
+	switch data := ev.Data().(type) {
+	case ttnpb.TxAcknowledgment_Result:
+		result = data
+	case *ttnpb.TxAcknowledgment:
+		result = data.GetResult()
+	default:
+		debugf("tx failure data seems to be of type %T", ev.Data())
+	}
+
+	reason, ok := txFailureReasons[result]
+	if !ok {
+		reason = "unknown"
+	}
+	for _, gwid := range eventGateways(ev) {
+		incEvent(gwDownlinkFailures.WithLabelValues(relabelGateway(gwid), reason), ev)
+	}
+}
+
+// scheduleFailureReasons are the names of the scheduling errors of the
+// Things Stack that are used as label values of
+// gateway_downlink_failures_total. Other errors are counted as "unknown".
+var scheduleFailureReasons = []string{
+	"conflict",
+	"duty_cycle",
+	"dwell_time",
+	"too_late",
+	"no_clock_sync",
+	"no_absolute_gateway_time",
+	"no_server_time",
+}
+
+// handleScheduleFailure counts the downlinks that could not be scheduled on
+// a gateway, so they were never sent. The event data is the error, whose
+// reason may be the cause of a more general error.
+func handleScheduleFailure(ev events.Event) {
+	details, ok := ev.Data().(*ttnpb.ErrorDetails)
+	if !ok {
+		debugf("schedule failure data seems to be of type %T", ev.Data())
+	}
+
+	reason := "unknown"
+	for d := details; d != nil; d = d.GetCause() {
+		if slices.Contains(scheduleFailureReasons, d.GetName()) {
+			reason = d.GetName()
+			break
+		}
+	}
+	for _, gwid := range eventGateways(ev) {
+		incEvent(gwDownlinkFailures.WithLabelValues(relabelGateway(gwid), reason), ev)
+	}
+}
+
+type pendingDownlink struct {
+	gwid string
+	sent time.Time
+	ids  []string
+	done bool
+}
+
+// downlinkAcks matches gs.down.send events with the tx ack events of the
+// same downlink by their correlation IDs. Only IDs like "ns:downlink:..."
+// are used, others like the one of the gateway connection are shared by
+// unrelated events. Downlinks without an ack within
+// timeout, and new ones while maxPendingDownlinks are waiting, are
+// discarded.
+type downlinkAcks struct {
+	timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*pendingDownlink
+	// order holds the pending downlinks in the order they were sent,
+	// including acked ones until they reach the front.
+	order []*pendingDownlink
+	n     int
+}
+
+func newDownlinkAcks(timeout time.Duration) *downlinkAcks {
+	return &downlinkAcks{
+		timeout: timeout,
+		pending: make(map[string]*pendingDownlink),
+	}
+}
+
+func (a *downlinkAcks) handleSend(ev events.Event) {
+	cids := downlinkCorrelationIDs(ev.CorrelationIds())
+	if len(cids) == 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expire(ev.Time())
+
+	// A downlink is sent by a single gateway.
+	var gwid string
+	for _, id := range ev.Identifiers() {
+		if gwid = id.GetGatewayIds().GetGatewayId(); gwid != "" {
+			break
+		}
+	}
+	if gwid == "" {
+		return
+	}
+	// The send event of a pending downlink may be received again, on a
+	// recycled stream for example. The first one is kept.
+	if a.lookup(cids) != nil {
+		return
+	}
+	if a.n >= maxPendingDownlinks {
+		pendingDownlinksDiscarded.WithLabelValues("full").Inc()
+		return
+	}
+
+	dl := &pendingDownlink{gwid: gwid, sent: ev.Time(), ids: cids}
+	for _, cid := range cids {
+		a.pending[cid] = dl
+	}
+	a.order = append(a.order, dl)
+	a.n++
+}
+
+func (a *downlinkAcks) handleAck(ev events.Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	dl := a.lookup(downlinkCorrelationIDs(ev.CorrelationIds()))
+	if dl == nil {
+		return
+	}
+	a.remove(dl)
+
+	if ev.Name() == evDownlinkTxSuccess {
+		observeEvent(gwDownlinkAckLatency.WithLabelValues(relabelGateway(dl.gwid)), ev.Time().Sub(dl.sent).Seconds(), ev)
+	}
+}
+
+// downlinkCorrelationIDs returns the correlation IDs that identify a
+// downlink.
+func downlinkCorrelationIDs(cids []string) []string {
+	var rtn []string
+	for _, cid := range cids {
+		if strings.Contains(cid, ":downlink:") {
+			rtn = append(rtn, cid)
+		}
+	}
+
+	return rtn
+}
+
+func (a *downlinkAcks) lookup(cids []string) *pendingDownlink {
+	for _, cid := range cids {
+		if dl, ok := a.pending[cid]; ok {
+			return dl
+		}
+	}
+
+	return nil
+}
+
+func (a *downlinkAcks) remove(dl *pendingDownlink) {
+	for _, cid := range dl.ids {
+		if a.pending[cid] == dl {
+			delete(a.pending, cid)
+		}
+	}
+	dl.done = true
+	a.n--
+}
+
+// expire discards the downlinks sent more than timeout before now.
+func (a *downlinkAcks) expire(now time.Time) {
+	for len(a.order) > 0 {
+		dl := a.order[0]
+		if !dl.done {
+			if now.Sub(dl.sent) <= a.timeout {
+				return
+			}
+			a.remove(dl)
+			pendingDownlinksDiscarded.WithLabelValues("timeout").Inc()
+		}
+		a.order[0] = nil
+		a.order = a.order[1:]
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+)
+
+func TestDownlinkFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		event  string
+		data   any
+		reason string
+	}{
+		{name: "tx result", event: evDownlinkTxFail, data: ttnpb.TxAcknowledgment_TOO_LATE, reason: "too_late"},
+		{name: "tx ack", event: evDownlinkTxFail, data: &ttnpb.TxAcknowledgment{Result: ttnpb.TxAcknowledgment_TX_FREQ}, reason: "tx_freq"},
+		{name: "tx unexpected data", event: evDownlinkTxFail, data: "x", reason: "unknown"},
+		{name: "schedule", event: evDownlinkScheduleFail, data: &ttnpb.ErrorDetails{Name: "duty_cycle"}, reason: "duty_cycle"},
+		{
+			name:   "schedule cause",
+			event:  evDownlinkScheduleFail,
+			data:   &ttnpb.ErrorDetails{Name: "schedule", Cause: &ttnpb.ErrorDetails{Name: "too_late"}},
+			reason: "too_late",
+		},
+		{name: "schedule unknown", event: evDownlinkScheduleFail, data: &ttnpb.ErrorDetails{Name: "no_path"}, reason: "unknown"},
+		{name: "schedule unexpected data", event: evDownlinkScheduleFail, data: 42, reason: "unknown"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gwid := "test-" + tc.name
+			defer deleteGatewayMetrics(gwid)
+
+			ev := gatewayEvent(tc.event, tc.data, gwid, gwid)
+			if tc.event == evDownlinkScheduleFail {
+				handleScheduleFailure(ev)
+			} else {
+				handleDownlinkFailure(ev)
+			}
+
+			// The duplicate identifier counts once.
+			if got := testutil.ToFloat64(gwDownlinkFailures.WithLabelValues(gwid, tc.reason)); got != 1 {
+				t.Errorf("gateway_downlink_failures_total{reason=%q} = %g, want 1", tc.reason, got)
+			}
+			if tc.reason != "unknown" && gwDownlinkFailures.DeleteLabelValues(gwid, "unknown") {
+				t.Errorf("the failure was counted as unknown as well")
+			}
+		})
+	}
+}
+
+func TestDownlinkMetricsEvents(t *testing.T) {
+	t.Setenv("LYTGAE_DOWNLINK_METRICS", "true")
+	cfg, err := configFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{evDownlinkSend, evDownlinkTxSuccess, evDownlinkTxFail, evDownlinkScheduleFail} {
+		if !slices.Contains(cfg.Events, name) {
+			t.Errorf("events %v do not include %s", cfg.Events, name)
+		}
+	}
+	if !slices.Contains(knownEventNames, evDownlinkScheduleFail) {
+		t.Errorf("%s is not a known event name", evDownlinkScheduleFail)
+	}
+}
+
+func TestDownlinkAcksDuplicateSend(t *testing.T) {
+	a := newDownlinkAcks(time.Minute)
+	send := gatewayEvent(evDownlinkSend, nil, "test-dup")
+	send.cids = []string{"gs:downlink:01"}
+	timeouts := testutil.ToFloat64(pendingDownlinksDiscarded.WithLabelValues("timeout"))
+
+	a.handleSend(send)
+	a.handleSend(send)
+	if a.n != 1 || len(a.order) != 1 {
+		t.Fatalf("%d pending downlinks in %d slots after a duplicate send, want 1 in 1", a.n, len(a.order))
+	}
+
+	ack := gatewayEvent(evDownlinkTxFail, nil, "test-dup")
+	ack.cids = send.cids
+	ack.time = send.time.Add(time.Second)
+	a.handleAck(ack)
+
+	a.expire(send.time.Add(time.Hour))
+	if a.n != 0 || len(a.order) != 0 {
+		t.Errorf("%d pending downlinks in %d slots after the ack, want none", a.n, len(a.order))
+	}
+	if got := testutil.ToFloat64(pendingDownlinksDiscarded.WithLabelValues("timeout")) - timeouts; got != 0 {
+		t.Errorf("%g acked downlinks were discarded as timed out", got)
+	}
+}
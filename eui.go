@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"go.thethings.network/lorawan-stack/v3/pkg/errors"
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+)
+
+// euiPrefix marks gateways in LYTGAE_GW or LYTGAE_GW_FILE given by their EUI instead of
+// their ID.
+const euiPrefix = "eui:"
+
+// euiGateways caches the gateway IDs of resolved EUIs, so every API key and
+// every reload of the gateway file looks each EUI up only once.
+var euiGateways = struct {
+	sync.Mutex
+	ids map[string]string
+}{ids: make(map[string]string)}
+
+// parseEUI parses a gateway EUI in hex, optionally separated by "-" or ":".
+func parseEUI(s string) ([]byte, error) {
+	s = strings.NewReplacer("-", "", ":", "").Replace(s)
+	eui, err := hex.DecodeString(s)
+	if err != nil || len(eui) != 8 {
+		return nil, fmt.Errorf("%q is not a valid EUI", s)
+	}
+
+	return eui, nil
+}
+
+// resolveGateways returns the gateways with entries prefixed by euiPrefix
+// replaced by the ID of the gateway with that EUI, looked up in the
+// registry.
+func (c *Client) resolveGateways(gateways []string) ([]string, error) {
+	rtn := make([]string, 0, len(gateways))
+	for _, gw := range gateways {
+		s, ok := strings.CutPrefix(gw, euiPrefix)
+		if !ok {
+			rtn = append(rtn, gw)
+			continue
+		}
+
+		gwid, err := c.resolveEUI(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", gw, err)
+		}
+		rtn = append(rtn, gwid)
+	}
+
+	return rtn, nil
+}
+
+func (c *Client) resolveEUI(s string) (string, error) {
+	eui, err := parseEUI(s)
+	if err != nil {
+		return "", err
+	}
+	key := formatEUI(eui)
+
+	euiGateways.Lock()
+	defer euiGateways.Unlock()
+
+	if gwid, ok := euiGateways.ids[key]; ok {
+		return gwid, nil
+	}
+
+	req := &ttnpb.GetGatewayIdentifiersForEUIRequest{Eui: eui}
+	ids, err := ttnpb.NewGatewayRegistryClient(c.conn).GetIdentifiersForEUI(c.ctx, req)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", fmt.Errorf("no gateway with EUI %s is registered", key)
+		}
+		return "", newClientError("GetIdentifiersForEUI", err, ErrDiscovery)
+	}
+
+	gwid := ids.GetGatewayId()
+	log.Printf("Gateway EUI %s is %s", key, gwid)
+	euiGateways.ids[key] = gwid
+
+	return gwid, nil
+}
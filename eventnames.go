@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"strings"
+)
+
+// knownEventNames are the event names of the Things Stack that are useful
+// for lytgae. The list may lag behind the server, so names missing from it
+// only cause a warning.
+var knownEventNames = []string{
+	"as.down.data.forward",
+	"as.up.data.forward",
+	"as.up.data.receive",
+	"as.up.join.forward",
+	"as.up.location.forward",
+	"gateway.create",
+	"gateway.delete",
+	"gateway.update",
+	"gs.down.schedule.fail",
+	"gs.down.send",
+	"gs.down.tx.fail",
+	"gs.down.tx.success",
+	"gs.gateway.connect",
+	"gs.gateway.connection.stats",
+	"gs.gateway.disconnect",
+	"gs.status.drop",
+	"gs.status.forward",
+	"gs.status.receive",
+	"gs.txack.drop",
+	"gs.txack.forward",
+	"gs.txack.receive",
+	"gs.up.drop",
+	"gs.up.forward",
+	"gs.up.receive",
+}
+
+// warnUnknownEventNames logs the requested stream names that are not known
+// event names. Regular expressions are not checked.
+func warnUnknownEventNames(names []string) {
+	for _, name := range names {
+		if strings.HasPrefix(name, "/") {
+			continue
+		}
+		if !slices.Contains(knownEventNames, name) {
+			log.Printf("Warning: %q is not a known event name, it might never be received", name)
+		}
+	}
+}
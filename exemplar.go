@@ -0,0 +1,52 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.thethings.network/lorawan-stack/v3/pkg/events"
+)
+
+// exemplars attaches the IDs of the causing event to some observations,
+// set with LYTGAE_OPENMETRICS. Only the OpenMetrics format carries them.
+var exemplars = false
+
+// eventExemplar returns the exemplar labels for ev: its ID and, if it
+// fits into the exemplar size limit, its first correlation ID.
+func eventExemplar(ev events.Event) prometheus.Labels {
+	if !exemplars || ev.UniqueID() == "" {
+		return nil
+	}
+
+	ex := prometheus.Labels{"event_id": ev.UniqueID()}
+	if cids := ev.CorrelationIds(); len(cids) > 0 {
+		n := utf8.RuneCountInString("event_id"+ev.UniqueID()) + utf8.RuneCountInString("correlation_id"+cids[0])
+		if n <= prometheus.ExemplarMaxRunes {
+			ex["correlation_id"] = cids[0]
+		}
+	}
+
+	return ex
+}
+
+// observeEvent observes v, with an exemplar for ev if enabled.
+func observeEvent(o prometheus.Observer, v float64, ev events.Event) {
+	if ex := eventExemplar(ev); ex != nil {
+		if eo, ok := o.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(v, ex)
+			return
+		}
+	}
+	o.Observe(v)
+}
+
+// incEvent increments c, with an exemplar for ev if enabled.
+func incEvent(c prometheus.Counter, ev events.Event) {
+	if ex := eventExemplar(ev); ex != nil {
+		if ea, ok := c.(prometheus.ExemplarAdder); ok {
+			ea.AddWithExemplar(1, ex)
+			return
+		}
+	}
+	c.Inc()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc/connectivity"
+)
+
+// failbackInterval is how often the primary server is checked while the
+// stream is connected to another one.
+const failbackInterval = time.Minute
+
+var activeServer = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "lytgae_active_server",
+	Help: "Always 1, labeled with the server the stream of an API key uses.",
+}, []string{"key", "server"})
+
+// useServer switches the client to servers[i]. The stream has to be
+// reconnected afterwards.
+func (c *Client) useServer(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	activeServer.DeleteLabelValues(c.name, c.servers[c.current])
+	c.current = i
+	c.server = c.servers[i]
+	c.conn = c.conns[i]
+	activeServer.WithLabelValues(c.name, c.server).Set(1)
+}
+
+func (c *Client) currentServer() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.current
+}
+
+// reconnect connects the event stream. It keeps trying until the client
+// context is done, and with more than one server moves on to the next one
+// after failoverAfter consecutive failures. An error is only returned once
+// the client context is done.
+func (c *Client) reconnect() error {
+	failures := 0
+	for {
+		err := c.connectEventstream()
+		if err == nil || c.ctx.Err() != nil {
+			return err
+		}
+
+		failures++
+		log.Printf("connect to %s: %v", c.server, err)
+		if len(c.conns) > 1 && failures >= c.failoverAfter {
+			next := (c.currentServer() + 1) % len(c.conns)
+			log.Printf("Failing over to %s", c.servers[next])
+			c.useServer(next)
+			failures = 0
+		}
+
+		select {
+		case <-c.clock.After(5 * time.Second):
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		}
+	}
+}
+
+// watchPrimary checks the connection to the primary server while another
+// server is in use, until done is closed. Once it is ready again, failback
+// is set and the stream is stopped, so that getEvents reconnects to the
+// primary.
+func (c *Client) watchPrimary(done <-chan struct{}, failback *atomic.Bool) {
+	t := c.clock.NewTicker(failbackInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C():
+		}
+
+		if c.currentServer() == 0 {
+			continue
+		}
+
+		c.mu.Lock()
+		primary := c.conns[0]
+		c.mu.Unlock()
+		primary.Connect()
+		if primary.GetState() == connectivity.Ready {
+			failback.Store(true)
+			c.stopStream()
+		}
+	}
+}
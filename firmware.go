@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxFirmwareInfoKeys bounds the number of labels of gateway_firmware_info.
+const maxFirmwareInfoKeys = 8
+
+var labelCharRe = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// gwFirmwareInfo.vec is nil unless LYTGAE_FIRMWARE_INFO_KEYS is set. Common
+// keys of the status versions are "firmware", "package", "platform",
+// "model" and "station" (Basic Station).
+var gwFirmwareInfo struct {
+	vec    *prometheus.GaugeVec
+	keys   []string
+	labels []string
+
+	mu   sync.Mutex
+	last map[string][]string
+}
+
+// initFirmwareInfo registers gateway_firmware_info with a label for each of
+// the given version keys. Characters that are not allowed in label names
+// are replaced with underscores.
+func initFirmwareInfo(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if len(keys) > maxFirmwareInfoKeys {
+		return fmt.Errorf("at most %d keys are allowed", maxFirmwareInfoKeys)
+	}
+
+	labels := []string{"gateway"}
+	for _, key := range keys {
+		label := labelCharRe.ReplaceAllString(key, "_")
+		if !labelNameRe.MatchString(label) {
+			return fmt.Errorf("%q is not a valid label name", label)
+		}
+		if slices.Contains(labels, label) {
+			return fmt.Errorf("duplicate label %q", label)
+		}
+		labels = append(labels, label)
+	}
+
+	gwFirmwareInfo.vec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_firmware_info",
+		Help: "Always 1, labeled with the versions reported in the last status of the gateway.",
+	}, labels)
+	gwFirmwareInfo.keys = keys
+	gwFirmwareInfo.labels = labels[1:]
+	gwFirmwareInfo.last = make(map[string][]string)
+
+	return prometheus.Register(gwFirmwareInfo.vec)
+}
+
+// publishFirmwareInfo exports the versions of a gateway status. The series
+// of the previous versions is removed, so an update doesn't leave a stale
+// series behind.
+func publishFirmwareInfo(gwid string, versions map[string]string) {
+	if gwFirmwareInfo.vec == nil || len(versions) == 0 {
+		return
+	}
+
+	values := []string{gwid}
+	for i, key := range gwFirmwareInfo.keys {
+		values = append(values, sanitizeLabel(gwFirmwareInfo.labels[i], versions[key]))
+	}
+
+	gwFirmwareInfo.mu.Lock()
+	defer gwFirmwareInfo.mu.Unlock()
+
+	if last, ok := gwFirmwareInfo.last[gwid]; ok && !slices.Equal(last, values) {
+		gwFirmwareInfo.vec.DeleteLabelValues(last...)
+	}
+	gwFirmwareInfo.last[gwid] = values
+	gwFirmwareInfo.vec.WithLabelValues(values...).Set(1)
+}
+
+// forgetFirmwareInfo drops the last versions of a gateway, whose series was
+// deleted.
+func forgetFirmwareInfo(gwid string) {
+	if gwFirmwareInfo.vec == nil {
+		return
+	}
+
+	gwFirmwareInfo.mu.Lock()
+	defer gwFirmwareInfo.mu.Unlock()
+
+	delete(gwFirmwareInfo.last, gwid)
+}
@@ -0,0 +1,56 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	fleetConnectedDesc = prometheus.NewDesc("fleet_gateways_connected",
+		"Number of tracked gateways that are connected.", nil, nil)
+	fleetUplinksDesc = prometheus.NewDesc("fleet_uplinks",
+		"Sum of the uplink counts of all tracked gateways.", nil, nil)
+	fleetDownlinksDesc = prometheus.NewDesc("fleet_downlinks",
+		"Sum of the downlink counts of all tracked gateways.", nil, nil)
+
+	legacyFleetUplinksDesc = prometheus.NewDesc("fleet_uplinks_total",
+		"Deprecated, use fleet_uplinks.", nil, nil)
+	legacyFleetDownlinksDesc = prometheus.NewDesc("fleet_downlinks_total",
+		"Deprecated, use fleet_downlinks.", nil, nil)
+)
+
+// fleetCollector computes fleet wide aggregates from a store snapshot at
+// scrape time.
+type fleetCollector struct {
+	store Store
+	// legacy also exports the metrics under their old names.
+	legacy bool
+}
+
+func (f fleetCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- fleetConnectedDesc
+	ch <- fleetUplinksDesc
+	ch <- fleetDownlinksDesc
+	if f.legacy {
+		ch <- legacyFleetUplinksDesc
+		ch <- legacyFleetDownlinksDesc
+	}
+}
+
+func (f fleetCollector) Collect(ch chan<- prometheus.Metric) {
+	var connected, uplinks, downlinks float64
+	for _, gw := range f.store.Snapshot() {
+		if gw.connected() {
+			connected++
+		}
+		uplinks += float64(gw.uplinkCount)
+		downlinks += float64(gw.downlinkCount)
+	}
+
+	// The per gateway counts start over when a gateway reconnects, so the
+	// sums are gauges.
+	ch <- prometheus.MustNewConstMetric(fleetConnectedDesc, prometheus.GaugeValue, connected)
+	ch <- prometheus.MustNewConstMetric(fleetUplinksDesc, prometheus.GaugeValue, uplinks)
+	ch <- prometheus.MustNewConstMetric(fleetDownlinksDesc, prometheus.GaugeValue, downlinks)
+	if f.legacy {
+		ch <- prometheus.MustNewConstMetric(legacyFleetUplinksDesc, prometheus.GaugeValue, uplinks)
+		ch <- prometheus.MustNewConstMetric(legacyFleetDownlinksDesc, prometheus.GaugeValue, downlinks)
+	}
+}
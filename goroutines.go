@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var monitorGoroutines = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "lytgae_goroutines",
+	Help: "Number of running goroutines started by lytgae itself, without those of the libraries.",
+})
+
+// spawn runs f in a goroutine counted in lytgae_goroutines. All long-lived
+// goroutines of lytgae are started with it, so a leak shows in the metric.
+func spawn(f func()) {
+	monitorGoroutines.Inc()
+	go func() {
+		defer monitorGoroutines.Dec()
+		f()
+	}()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// metricTypes are the kinds of gateway metrics that can be enabled per
+// group. The first four are the type label values of gateway_messages and
+// gateway_timestamp_seconds.
+var metricTypes = []string{"connect", "uplink", "downlink", "txack", "subband", "status"}
+
+// gwGroups assigns gateways to groups by ID pattern and holds the metric
+// types enabled for each group, set with LYTGAE_GW_GROUPS and
+// LYTGAE_GROUP_TYPES. Gateways without a group, and groups without an
+// entry, get all metric types.
+var gwGroups struct {
+	patterns []groupPattern
+	types    map[string][]string
+}
+
+type groupPattern struct {
+	pattern string
+	group   string
+}
+
+// setGatewayGroups configures the groups from a comma separated list of
+// pattern=group entries, using path.Match patterns, and the enabled types
+// from a comma separated list of group=type+type entries.
+func setGatewayGroups(groups, types string) error {
+	gwGroups.patterns = nil
+	gwGroups.types = make(map[string][]string)
+
+	if groups != "" {
+		for _, entry := range strings.Split(groups, ",") {
+			pattern, group, ok := strings.Cut(entry, "=")
+			if !ok || pattern == "" || group == "" {
+				return fmt.Errorf("invalid group %q, expected pattern=group", entry)
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("%s: %v", pattern, err)
+			}
+			gwGroups.patterns = append(gwGroups.patterns, groupPattern{pattern: pattern, group: group})
+		}
+	}
+
+	if types != "" {
+		for _, entry := range strings.Split(types, ",") {
+			group, list, ok := strings.Cut(entry, "=")
+			if !ok || group == "" {
+				return fmt.Errorf("invalid entry %q, expected group=type+type", entry)
+			}
+			var enabled []string
+			if list != "" {
+				enabled = strings.Split(list, "+")
+			}
+			for _, typ := range enabled {
+				if !slices.Contains(metricTypes, typ) {
+					return fmt.Errorf("unknown metric type %q, expected one of %s", typ, strings.Join(metricTypes, ", "))
+				}
+			}
+			gwGroups.types[group] = enabled
+		}
+	}
+
+	return nil
+}
+
+// gatewayGroup returns the group of the first pattern matching the gateway
+// ID, or the empty string.
+func gatewayGroup(id string) string {
+	for _, p := range gwGroups.patterns {
+		if ok, _ := path.Match(p.pattern, id); ok {
+			return p.group
+		}
+	}
+
+	return ""
+}
+
+// metricTypeEnabled reports whether metrics of the given type are exported
+// for the gateway.
+func metricTypeEnabled(id, typ string) bool {
+	enabled, ok := gwGroups.types[gatewayGroup(id)]
+	if !ok {
+		return true
+	}
+
+	return slices.Contains(enabled, typ)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+)
+
+// gatewayCacheStale is the age after which a warning is logged when the
+// cached gateways are used.
+const gatewayCacheStale = 24 * time.Hour
+
+var errGatewayCacheInvalid = errors.New("invalid gateway cache")
+
+// gatewayCache holds the gateways discovered per API key, by key name.
+type gatewayCache map[string]gatewayCacheEntry
+
+type gatewayCacheEntry struct {
+	Time     time.Time       `json:"time"`
+	Gateways []cachedGateway `json:"gateways"`
+}
+
+// cachedGateway holds the fields of a gateway that are requested from
+// the registry.
+type cachedGateway struct {
+	ID               string            `json:"id"`
+	EUI              []byte            `json:"eui,omitempty"`
+	FrequencyPlanIDs []string          `json:"frequency_plan_ids,omitempty"`
+	Attributes       map[string]string `json:"attributes,omitempty"`
+}
+
+func loadGatewayCache(name string) (gatewayCache, error) {
+	cache := make(gatewayCache)
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return cache, err
+	}
+	if err := json.Unmarshal(b, &cache); err != nil {
+		return make(gatewayCache), fmt.Errorf("%s: %w: %v", name, errGatewayCacheInvalid, err)
+	}
+
+	return cache, nil
+}
+
+// writeGatewayCache replaces the cached gateways of the key. The file is
+// replaced atomically, so a crash can't leave a truncated cache behind. A
+// cache that can't be decoded is replaced by one holding only this key.
+func writeGatewayCache(name, key string, gws []*ttnpb.Gateway) error {
+	cache, err := loadGatewayCache(name)
+	switch {
+	case err == nil, errors.Is(err, os.ErrNotExist):
+	case errors.Is(err, errGatewayCacheInvalid):
+		log.Printf("%v, replacing it", err)
+	default:
+		return err
+	}
+
+	entry := gatewayCacheEntry{Time: time.Now()}
+	for _, gw := range gws {
+		entry.Gateways = append(entry.Gateways, cachedGateway{
+			ID:               gw.IDString(),
+			EUI:              gw.GetIds().GetEui(),
+			FrequencyPlanIDs: gw.GetFrequencyPlanIds(),
+			Attributes:       gw.GetAttributes(),
+		})
+	}
+	cache[key] = entry
+
+	b, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+// readGatewayCache returns the cached gateways of the key and the time they
+// were discovered.
+func readGatewayCache(name, key string) ([]*ttnpb.Gateway, time.Time, error) {
+	cache, err := loadGatewayCache(name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	entry, ok := cache[key]
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("no gateways cached for key %s", key)
+	}
+
+	var rtn []*ttnpb.Gateway
+	for _, gw := range entry.Gateways {
+		rtn = append(rtn, &ttnpb.Gateway{
+			Ids:              &ttnpb.GatewayIdentifiers{GatewayId: gw.ID, Eui: gw.EUI},
+			FrequencyPlanIds: gw.FrequencyPlanIDs,
+			Attributes:       gw.Attributes,
+		})
+	}
+
+	return rtn, entry.Time, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+)
+
+func TestWriteGatewayCache(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		existing string
+		// keep is a key whose cached gateways must survive the write.
+		keep string
+	}{
+		{name: "missing"},
+		{name: "invalid", existing: `{"other": {"gateways": [`},
+		{name: "other key", existing: `{"other": {"gateways": [{"id": "gw-other"}]}}`, keep: "other"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			name := filepath.Join(dir, "cache.json")
+			if tc.existing != "" {
+				if err := os.WriteFile(name, []byte(tc.existing), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			gws := []*ttnpb.Gateway{{Ids: &ttnpb.GatewayIdentifiers{GatewayId: "gw-1"}}}
+			if err := writeGatewayCache(name, "key", gws); err != nil {
+				t.Fatalf("writeGatewayCache: %v", err)
+			}
+
+			got, _, err := readGatewayCache(name, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0].GetIds().GetGatewayId() != "gw-1" {
+				t.Errorf("cached gateways = %v, want gw-1", got)
+			}
+			if tc.keep != "" {
+				if _, _, err := readGatewayCache(name, tc.keep); err != nil {
+					t.Errorf("the gateways of the other key were dropped: %v", err)
+				}
+			}
+
+			// Only the cache is left, the temporary file was renamed.
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("directory holds %d files, want only the cache", len(entries))
+			}
+		})
+	}
+}
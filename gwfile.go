@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+)
+
+// gatewayFileDebounce is how long LYTGAE_GW_FILE has to be left alone
+// before a change is applied.
+const gatewayFileDebounce = time.Second
+
+// watchGatewayFile watches LYTGAE_GW_FILE and applies changes to the
+// gateways of the clients until ctx is done. The directory is watched
+// instead of the file, so files replaced by a rename, like editors and
+// ConfigMap updates do, are picked up as well. A change is only applied
+// once there were no events for gatewayFileDebounce, so a file that is
+// still being written is not read half way.
+func watchGatewayFile(ctx context.Context, clock Clock, cfg *Config, clients []*Client, store Store) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("LYTGAE_GW_FILE: %v, changes are not applied", err)
+		return
+	}
+	defer w.Close()
+	if err := w.Add(filepath.Dir(cfg.GatewayFile)); err != nil {
+		log.Printf("LYTGAE_GW_FILE: %v, changes are not applied", err)
+		return
+	}
+
+	applied, _ := os.Stat(cfg.GatewayFile)
+	var debounce <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("LYTGAE_GW_FILE: %v", err)
+			continue
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			// Any file of the directory may be the target of a symlink
+			// to the gateway file, whether this one changed is decided
+			// by its stat below.
+			if ev.Op != fsnotify.Chmod {
+				debounce = clock.After(gatewayFileDebounce)
+			}
+			continue
+		case <-debounce:
+			debounce = nil
+		}
+
+		fi, err := os.Stat(cfg.GatewayFile)
+		if err != nil {
+			log.Printf("LYTGAE_GW_FILE: %v", err)
+			continue
+		}
+		if sameFileInfo(fi, applied) {
+			continue
+		}
+
+		applied = fi
+		gateways, err := readGatewayFile(cfg.GatewayFile)
+		if err != nil {
+			log.Printf("LYTGAE_GW_FILE: %v, keeping the current gateways", err)
+			continue
+		}
+		if len(clients) > 0 {
+			gateways, err = clients[0].resolveGateways(gateways)
+			if err != nil {
+				log.Printf("LYTGAE_GW_FILE: %v, keeping the current gateways", err)
+				continue
+			}
+		}
+		applyGateways(cfg, clients, store, gateways)
+	}
+}
+
+func sameFileInfo(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return false
+	}
+
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+// applyGateways hands each client the gateways of its API key and restarts
+// the streams whose gateways changed. Removed gateways are deleted from the
+// store, along with their series.
+func applyGateways(cfg *Config, clients []*Client, store Store, gateways []string) {
+	byKey := make(map[int][]string)
+	for _, gwid := range gateways {
+		key := cfg.keyIndex(gwid)
+		if key < 0 {
+			log.Printf("Gateway %s matches no API key, ignoring it", gwid)
+			continue
+		}
+		byKey[key] = append(byKey[key], gwid)
+	}
+
+	var removedAll []string
+	for _, c := range clients {
+		added, removed := c.setGateways(byKey[c.key])
+		delete(byKey, c.key)
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+
+		log.Printf("key %s: added gateways %v, removed gateways %v", c.name, added, removed)
+		for _, gwid := range added {
+			precreateGatewaySeries(gwid)
+		}
+		for _, gwid := range removed {
+			store.Delete(gwid)
+		}
+		removedAll = append(removedAll, removed...)
+		// getEvents reconnects with the new identifiers.
+		c.resubscribe.Store(true)
+		c.stopStream()
+	}
+
+	// With LYTGAE_GW_RELABEL a removed gateway may share its label with
+	// one that is still watched, the series are kept for that one then.
+	watched := watchedLabels(clients)
+	for _, gwid := range removedAll {
+		if !watched[relabelGateway(gwid)] {
+			deleteGatewayMetrics(gwid)
+		}
+	}
+
+	// Keys that had nothing to subscribe to at startup have no client.
+	for key, gwids := range byKey {
+		log.Printf("Gateways %v use API key #%d, which is only picked up after a restart", gwids, key)
+	}
+}
+
+// watchedLabels returns the gateway labels of the gateways of all clients.
+func watchedLabels(clients []*Client) map[string]bool {
+	labels := make(map[string]bool)
+	for _, c := range clients {
+		c.mu.Lock()
+		for _, id := range c.gateways {
+			labels[relabelGateway(id.GetGatewayIds().GetGatewayId())] = true
+		}
+		c.mu.Unlock()
+	}
+
+	return labels
+}
+
+// setGateways replaces the gateways of the client and returns the IDs that
+// were added and removed.
+func (c *Client) setGateways(gwids []string) (added, removed []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var current []string
+	for _, id := range c.gateways {
+		current = append(current, id.GetGatewayIds().GetGatewayId())
+	}
+
+	for _, gwid := range gwids {
+		if !slices.Contains(current, gwid) {
+			added = append(added, gwid)
+		}
+	}
+	for _, gwid := range current {
+		if !slices.Contains(gwids, gwid) {
+			removed = append(removed, gwid)
+			delete(c.lastEvent, gwid)
+		}
+	}
+
+	c.gateways = nil
+	for _, gwid := range gwids {
+		c.gateways = append(c.gateways, (&ttnpb.GatewayIdentifiers{GatewayId: gwid}).GetEntityIdentifiers())
+	}
+	gatewaysConfigured.WithLabelValues(c.name).Set(float64(len(c.gateways)))
+
+	return added, removed
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	gwtest "github.com/feuerrot/lytgae/internal/testutil"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestApplyGatewaysKeepsUnchanged(t *testing.T) {
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	last := clock.Now().Add(-time.Minute)
+	cfg := &Config{APIKeys: []APIKey{{Pattern: "*"}}}
+	store := newMemoryStore("a", time.Minute, 0, clock)
+	c := &Client{name: "test"}
+	defer deleteGatewayMetrics("test-keep")
+	defer deleteGatewayMetrics("test-drop")
+
+	c.setGateways([]string{"test-keep", "test-drop"})
+	for i, gwid := range []string{"test-keep", "test-drop"} {
+		stats := gwtest.GatewayConnectionStats(
+			gwtest.WithConnectedAt(clock.Now().Add(-time.Hour)),
+			gwtest.WithUplinks(uint64(i+5), last),
+		)
+		updateGateway(store, clock, gwid, stats, clock.Now(), true)
+	}
+
+	applyGateways(cfg, []*Client{c}, store, []string{"test-keep"})
+
+	if !c.resubscribe.Load() {
+		t.Errorf("the stream is not marked to resubscribe")
+	}
+
+	if _, ok := store.Get("test-drop"); ok {
+		t.Errorf("the removed gateway is still in the store")
+	}
+	if gwCount.DeleteLabelValues("test-drop", "uplink") {
+		t.Errorf("the series of the removed gateway still exist")
+	}
+
+	gw, ok := store.Get("test-keep")
+	if !ok || gw.uplinkCount != 5 {
+		t.Fatalf("the store holds %+v for the unchanged gateway, want 5 uplinks", gw)
+	}
+	if got := testutil.ToFloat64(gwCount.WithLabelValues("test-keep", "uplink")); got != 5 {
+		t.Errorf("gateway_messages of the unchanged gateway = %g, want 5", got)
+	}
+	if got := testutil.ToFloat64(gwTime.WithLabelValues("test-keep", "uplink")); got != float64(last.Unix()) {
+		t.Errorf("gateway_timestamp_seconds of the unchanged gateway = %g, want %d", got, last.Unix())
+	}
+	if got := testutil.ToFloat64(gwConnected.WithLabelValues("test-keep")); got != 1 {
+		t.Errorf("gateway_connected of the unchanged gateway = %g, want 1", got)
+	}
+}
+
+func TestApplyGatewaysKeepsSharedLabel(t *testing.T) {
+	if err := setGatewayRelabel("^test-(eu|us)-=>test-"); err != nil {
+		t.Fatal(err)
+	}
+	defer setGatewayRelabel("")
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	cfg := &Config{APIKeys: []APIKey{{Pattern: "*"}}}
+	store := newMemoryStore("a", time.Minute, 0, clock)
+	c := &Client{name: "test"}
+	defer deleteGatewayMetrics("test-shared")
+
+	c.setGateways([]string{"test-us-shared", "test-eu-shared"})
+	for i, gwid := range []string{"test-us-shared", "test-eu-shared"} {
+		stats := gwtest.GatewayConnectionStats(
+			gwtest.WithConnectedAt(clock.Now().Add(-time.Hour)),
+			gwtest.WithUplinks(uint64(i+5), clock.Now().Add(-time.Minute)),
+		)
+		updateGateway(store, clock, gwid, stats, clock.Now(), true)
+	}
+
+	applyGateways(cfg, []*Client{c}, store, []string{"test-eu-shared"})
+
+	if got := testutil.ToFloat64(gwCount.WithLabelValues("test-shared", "uplink")); got != 6 {
+		t.Errorf("gateway_messages of the shared label = %g, want 6", got)
+	}
+	if got := testutil.ToFloat64(gwConnected.WithLabelValues("test-shared")); got != 1 {
+		t.Errorf("gateway_connected of the shared label = %g, want 1", got)
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.thethings.network/lorawan-stack/v3/pkg/events"
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+)
+
+const (
+	evConnectionStats = "gs.gateway.connection.stats"
+	evGatewayUplink   = "gs.up.receive"
+	evApplicationUp   = "as.up.data.forward"
+	evGatewayStatus   = "gs.status.receive"
+	// evGatewayAdmin matches the administrative gateway events, like
+	// gs.gateway.connect and gs.gateway.disconnect.
+	evGatewayAdmin = "gs.gateway.*"
+)
+
+var (
+	eventsReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "lytgae_events_received_total",
+		Help: "Number of events received from all streams.",
+	})
+	eventsIgnored = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lytgae_events_ignored_total",
+		Help: "Number of received events without a handler, by event name.",
+	}, []string{"name"})
+	eventProcessing = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "lytgae_event_processing_seconds",
+		Help: "Time spent handling an event, by event name.",
+		// 100µs to 1.6s
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+	}, []string{"name"})
+	gwHeartbeats = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_heartbeats_total",
+		Help: "Number of status messages received from the gateway.",
+	}, []string{"gateway"})
+	gatewayClockAnomalies = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lytgae_gateway_clock_anomalies_total",
+		Help: "Number of connection stats with timestamps in the future, by gateway.",
+	}, []string{"gateway"})
+	gatewayAdminEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lytgae_gateway_admin_events_total",
+		Help: "Number of administrative gateway events, by event name.",
+	}, []string{"name"})
+)
+
+type eventHandler func(ev events.Event)
+
+// dispatcher routes events to the handler registered for their name. Names
+// ending in "*" match all events with that prefix, unless there is a
+// handler for the exact name.
+type dispatcher map[string]eventHandler
+
+// newDispatcher returns a dispatcher for the given event names, looked up in
+// the handler registry.
+func newDispatcher(names []string, registry map[string]eventHandler) (dispatcher, error) {
+	d := make(dispatcher, len(names))
+	for _, name := range names {
+		h, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("no handler for event %q", name)
+		}
+		d[name] = h
+	}
+
+	return d, nil
+}
+
+func (d dispatcher) dispatch(ev events.Event) {
+	eventsReceived.Inc()
+
+	h, ok := d[ev.Name()]
+	if !ok {
+		h, ok = d.match(ev.Name())
+	}
+	if !ok {
+		eventsIgnored.WithLabelValues(ev.Name()).Inc()
+		return
+	}
+
+	start := time.Now()
+	h(ev)
+	eventProcessing.WithLabelValues(ev.Name()).Observe(time.Since(start).Seconds())
+}
+
+func (d dispatcher) match(name string) (eventHandler, bool) {
+	for pattern, h := range d {
+		prefix, ok := strings.CutSuffix(pattern, "*")
+		if ok && strings.HasPrefix(name, prefix) {
+			return h, true
+		}
+	}
+
+	return nil, false
+}
+
+// streamName returns the name to request from the server for an event name
+// of the dispatcher. Prefix patterns are sent as regular expressions, which
+// the server expects enclosed in slashes.
+func streamName(name string) string {
+	prefix, ok := strings.CutSuffix(name, "*")
+	if !ok {
+		return name
+	}
+
+	return "/^" + regexp.QuoteMeta(prefix) + "/"
+}
+
+func handleConnectionStats(ev events.Event, store Store, clock Clock) {
+	data, ok := ev.Data().(*ttnpb.GatewayConnectionStats)
+	if !ok {
+		log.Printf("event data seems to be of type %T", ev.Data())
+		return
+	}
+
+	for _, gwid := range eventGateways(ev) {
+		updateGateway(store, clock, gwid, data, ev.Time(), true)
+	}
+}
+
+// eventGateways returns the gateway IDs among the identifiers of ev, in
+// order and each only once. Identifiers of other entities are skipped, they
+// would otherwise end up as a gateway with an empty ID.
+func eventGateways(ev events.Event) []string {
+	var rtn []string
+	for _, id := range ev.Identifiers() {
+		gwid := id.GetGatewayIds().GetGatewayId()
+		if gwid == "" || slices.Contains(rtn, gwid) {
+			continue
+		}
+		rtn = append(rtn, gwid)
+	}
+
+	return rtn
+}
+
+// clampTimestamps makes updateGateway replace timestamps in the future with
+// the current time, set with LYTGAE_CLAMP_TIMESTAMPS.
+var clampTimestamps = true
+
+// checkTimestamps counts connection stats of gateways with timestamps in
+// the future, which would make the age metrics negative, and clamps them to
+// now if clampTimestamps is set.
+func checkTimestamps(gw *Gateway, now time.Time) {
+	anomaly := false
+	for _, t := range []*time.Time{&gw.connectTime, &gw.uplinkTime, &gw.downlinkTime, &gw.txAckTime} {
+		if !t.After(now) {
+			continue
+		}
+		anomaly = true
+		if clampTimestamps {
+			*t = now
+		}
+	}
+
+	if anomaly {
+		debugf("Gateway %s reported timestamps in the future", gw.id)
+		gatewayClockAnomalies.WithLabelValues(relabelGateway(gw.id)).Inc()
+	}
+}
+
+// gwLogMinInterval is the minimum time between two messages logged about
+// the same gateway, set with LYTGAE_GW_LOG_MIN_INTERVAL. Messages in
+// between are dropped, the metrics are updated regardless.
+var gwLogMinInterval time.Duration
+
+// throttleGatewayLog reports whether a message about gw may be logged now
+// and if so, records the time in gw.
+func throttleGatewayLog(gw *Gateway, now time.Time) bool {
+	if gwLogMinInterval > 0 && !gw.lastLogged.IsZero() && now.Sub(gw.lastLogged) < gwLogMinInterval {
+		return false
+	}
+	gw.lastLogged = now
+
+	return true
+}
+
+// statsIntervalWeight is the weight of the latest inter-arrival time in the
+// stats interval estimate.
+const statsIntervalWeight = 0.2
+
+// updateGateway stores the connection stats of a gateway and publishes the
+// metrics derived from them. fromEvent is false for stats that were fetched
+// instead of streamed, which do not count towards the stats interval.
+func updateGateway(store Store, clock Clock, gwid string, data *ttnpb.GatewayConnectionStats, t time.Time, fromEvent bool) {
+	prev, known := store.Get(gwid)
+
+	gw := Gateway{
+		id:            gwid,
+		lastSeen:      t,
+		protocol:      data.GetProtocol(),
+		connectTime:   data.GetConnectedAt().AsTime(),
+		disconnTime:   data.GetDisconnectedAt().AsTime(),
+		uplinkCount:   data.GetUplinkCount(),
+		downlinkCount: data.GetDownlinkCount(),
+		txAckCount:    data.GetTxAcknowledgmentCount(),
+		uplinkTime:    data.GetLastUplinkReceivedAt().AsTime(),
+		downlinkTime:  data.GetLastDownlinkReceivedAt().AsTime(),
+		txAckTime:     data.GetLastTxAcknowledgmentReceivedAt().AsTime(),
+		statsTime:     prev.statsTime,
+		statsInterval: prev.statsInterval,
+		snrAvg:        prev.snrAvg,
+		snrSamples:    prev.snrSamples,
+		uplinkBytes:   prev.uplinkBytes,
+		downlinkBytes: prev.downlinkBytes,
+		lastLogged:    prev.lastLogged,
+		connectedAt:   data.GetConnectedAt().AsTime(),
+	}
+	checkTimestamps(&gw, clock.Now())
+
+	if fromEvent {
+		if !gw.statsTime.IsZero() && t.After(gw.statsTime) {
+			d := t.Sub(gw.statsTime)
+			if gw.statsInterval == 0 {
+				gw.statsInterval = d
+			} else {
+				gw.statsInterval = time.Duration(statsIntervalWeight*float64(d) + (1-statsIntervalWeight)*float64(gw.statsInterval))
+			}
+		}
+		gw.statsTime = t
+	}
+
+	// The messages are decided on first, so the throttle state is stored
+	// along with the stats.
+	var msgs []string
+	if !known || prev.connected() != gw.connected() {
+		state := "disconnected"
+		if gw.connected() {
+			state = "connected"
+		}
+		msgs = append(msgs, fmt.Sprintf("Gateway %s, now %s", gw, state))
+	}
+	protocolChanged := known && prev.protocol != "" && gw.protocol != "" && prev.protocol != gw.protocol
+	if protocolChanged {
+		msgs = append(msgs, fmt.Sprintf("Gateway %s switched protocol from %s to %s", gwid, prev.protocol, gw.protocol))
+	}
+	if len(msgs) > 0 && !throttleGatewayLog(&gw, clock.Now()) {
+		msgs = nil
+	}
+
+	// Gateways beyond the limit get no series at all.
+	if !store.Upsert(gw) {
+		return
+	}
+	for _, msg := range msgs {
+		log.Print(msg)
+	}
+
+	if metricTypeEnabled(gwid, "connect") {
+		v := 0.0
+		if gw.connected() {
+			v = 1
+		}
+		gwConnected.WithLabelValues(relabelGateway(gwid)).Set(v)
+
+		// The first stats of a gateway only create the series, so rate()
+		// sees the first reconnect.
+		connects := gwConnects.WithLabelValues(relabelGateway(gwid))
+		if known && !prev.connectedAt.IsZero() && gw.connectedAt.Unix() != 0 && !gw.connectedAt.Equal(prev.connectedAt) {
+			connects.Inc()
+		}
+	}
+	if gw.statsInterval != 0 {
+		gwStatsInterval.WithLabelValues(relabelGateway(gwid)).Set(gw.statsInterval.Seconds())
+	}
+	gw.publish()
+	if protocolChanged {
+		gwProtocolChanges.WithLabelValues(relabelGateway(gwid)).Inc()
+	}
+
+	if metricTypeEnabled(gwid, "subband") {
+		publishSubBands(relabelGateway(gwid), data.GetSubBands())
+	}
+	if metricTypeEnabled(gwid, "status") {
+		publishStatus(relabelGateway(gwid), data.GetLastStatus())
+	}
+}
+
+// logGateways logs all gateways of the store. Their metrics are published
+// as well, which covers gateways that were merged in from a shared store.
+func logGateways(store Store) {
+	for _, gw := range store.Snapshot() {
+		gw.publish()
+		log.Printf("Gateway %s", gw)
+	}
+}
+
+// logSummary calls logGateways every interval until ctx is done.
+func logSummary(ctx context.Context, store Store, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			logGateways(store)
+		}
+	}
+}
+
+// publishSubBands exports the downlink utilization of each sub-band. The
+// number of sub-bands depends on the frequency plan of the gateway.
+func publishSubBands(gwid string, subBands []*ttnpb.GatewayConnectionStats_SubBand) {
+	for _, sb := range subBands {
+		if sb == nil {
+			continue
+		}
+		minFreq := strconv.FormatUint(sb.GetMinFrequency(), 10)
+		maxFreq := strconv.FormatUint(sb.GetMaxFrequency(), 10)
+		gwSubBandUtilization.WithLabelValues(gwid, minFreq, maxFreq).Set(float64(sb.GetDownlinkUtilization()))
+		gwSubBandUtilizationLimit.WithLabelValues(gwid, minFreq, maxFreq).Set(float64(sb.GetDownlinkUtilizationLimit()))
+	}
+}
+
+// handleGatewayAdmin logs administrative gateway events. They carry no
+// stats, so the gateway metrics are left alone.
+func handleGatewayAdmin(ev events.Event, store Store, clock Clock) {
+	gatewayAdminEvents.WithLabelValues(ev.Name()).Inc()
+
+	for _, gwid := range eventGateways(ev) {
+		if gw, ok := store.Get(gwid); ok {
+			if !throttleGatewayLog(&gw, clock.Now()) {
+				continue
+			}
+			store.Upsert(gw)
+		}
+		log.Printf("Gateway %s: %s", gwid, ev.Name())
+	}
+}
+
+// handleStatus counts the status messages of gateways, which packet
+// forwarders send far more often than the connection stats are updated.
+func handleStatus(ev events.Event) {
+	for _, gwid := range eventGateways(ev) {
+		incEvent(gwHeartbeats.WithLabelValues(relabelGateway(gwid)), ev)
+	}
+}
+
+// snrSmoothing is the weight of the latest uplink in the SNR average, set
+// with LYTGAE_SNR_SMOOTHING.
+var snrSmoothing = 0.1
+
+// uplinkPayloadMetric makes handleUplink export the payload sizes, set with
+// LYTGAE_UPLINK_PAYLOAD_METRIC. The uplinks are also streamed for the SNR
+// and bandwidthMetrics, which must not create its series.
+var uplinkPayloadMetric = false
+
+func handleUplink(ev events.Event, store Store) {
+	data, ok := ev.Data().(*ttnpb.GatewayUplinkMessage)
+	if !ok {
+		log.Printf("event data seems to be of type %T", ev.Data())
+		return
+	}
+
+	msg := data.GetMessage()
+	if msg == nil {
+		return
+	}
+
+	for _, gwid := range eventGateways(ev) {
+		if uplinkPayloadMetric {
+			observeEvent(gwUplinkPayload.WithLabelValues(relabelGateway(gwid)), float64(len(msg.GetRawPayload())), ev)
+		}
+		if bandwidthMetrics {
+			addPayloadBytes(store, gwid, true, len(msg.GetRawPayload()))
+		}
+	}
+
+	for _, md := range msg.GetRxMetadata() {
+		if gwid := md.GetGatewayIds().GetGatewayId(); gwid != "" {
+			updateSNR(store, gwid, float64(md.GetSnr()))
+		}
+	}
+}
+
+// updateSNR adds an SNR sample to the average of a gateway. Gateways
+// without connection stats are not in the store yet and skipped.
+func updateSNR(store Store, gwid string, snr float64) {
+	gw, ok := store.Get(gwid)
+	if !ok {
+		return
+	}
+
+	if gw.snrSamples == 0 {
+		gw.snrAvg = snr
+	} else {
+		gw.snrAvg = snrSmoothing*snr + (1-snrSmoothing)*gw.snrAvg
+	}
+	gw.snrSamples++
+
+	if store.Upsert(gw) {
+		gwSNRAvg.WithLabelValues(relabelGateway(gwid)).Set(gw.snrAvg)
+	}
+}
+
+func handleApplicationUplink(ev events.Event, ag *appGateways, labels *appLabels) {
+	data, ok := ev.Data().(*ttnpb.ApplicationUp)
+	if !ok {
+		log.Printf("event data seems to be of type %T", ev.Data())
+		return
+	}
+
+	app := data.GetEndDeviceIds().GetApplicationIds().GetApplicationId()
+	if app == "" {
+		return
+	}
+
+	for _, md := range data.GetUplinkMessage().GetRxMetadata() {
+		if gwid := md.GetGatewayIds().GetGatewayId(); gwid != "" {
+			ag.markSeen(app, gwid, ev.Time())
+			labels.countAppUplink(app, gwid)
+		}
+	}
+}
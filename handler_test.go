@@ -0,0 +1,304 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	gwtest "github.com/feuerrot/lytgae/internal/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"go.thethings.network/lorawan-stack/v3/pkg/events"
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+)
+
+// testEvent is an event of the gateways with the given IDs. Methods the
+// handlers don't use panic.
+type testEvent struct {
+	events.Event
+	name string
+	time time.Time
+	ids  []*ttnpb.EntityIdentifiers
+	data any
+	cids []string
+}
+
+func gatewayEvent(name string, data any, gwids ...string) *testEvent {
+	ev := &testEvent{name: name, time: time.Unix(1700000000, 0), data: data}
+	for _, gwid := range gwids {
+		ev.ids = append(ev.ids, (&ttnpb.GatewayIdentifiers{GatewayId: gwid}).GetEntityIdentifiers())
+	}
+	return ev
+}
+
+func (ev *testEvent) Name() string                            { return ev.name }
+func (ev *testEvent) Time() time.Time                         { return ev.time }
+func (ev *testEvent) Identifiers() []*ttnpb.EntityIdentifiers { return ev.ids }
+func (ev *testEvent) Data() any                               { return ev.data }
+func (ev *testEvent) UniqueID() string                        { return "" }
+func (ev *testEvent) CorrelationIds() []string                { return ev.cids }
+
+// gatewayCollector collects the series of a single gateway of a vector.
+type gatewayCollector struct {
+	vec  prometheus.Collector
+	gwid string
+}
+
+func (c gatewayCollector) Describe(ch chan<- *prometheus.Desc) { c.vec.Describe(ch) }
+
+func (c gatewayCollector) Collect(ch chan<- prometheus.Metric) {
+	all := make(chan prometheus.Metric)
+	go func() {
+		c.vec.Collect(all)
+		close(all)
+	}()
+
+	for m := range all {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			continue
+		}
+		for _, l := range pb.GetLabel() {
+			if l.GetName() == "gateway" && l.GetValue() == c.gwid {
+				ch <- m
+			}
+		}
+	}
+}
+
+func TestUpdateGatewayMetrics(t *testing.T) {
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	connected := clock.Now().Add(-time.Hour)
+	last := clock.Now().Add(-time.Minute)
+
+	for _, tc := range []struct {
+		name  string
+		stats *ttnpb.GatewayConnectionStats
+		// times and counts hold the expected series by type, types not
+		// listed must have no series.
+		times        map[string]time.Time
+		counts       map[string]float64
+		disconnected bool
+	}{
+		{
+			name:  "never connected",
+			stats: gwtest.GatewayConnectionStats(),
+		},
+		{
+			name:  "no messages",
+			stats: gwtest.GatewayConnectionStats(gwtest.WithConnectedAt(connected)),
+			times: map[string]time.Time{"connect": connected},
+		},
+		{
+			name: "uplinks only",
+			stats: gwtest.GatewayConnectionStats(
+				gwtest.WithConnectedAt(connected),
+				gwtest.WithUplinks(5, last),
+			),
+			times:  map[string]time.Time{"connect": connected, "uplink": last},
+			counts: map[string]float64{"uplink": 5},
+		},
+		{
+			name: "disconnected",
+			stats: gwtest.GatewayConnectionStats(
+				gwtest.WithConnectedAt(connected),
+				gwtest.WithDisconnectedAt(last),
+				gwtest.WithUplinks(5, last),
+			),
+			times:        map[string]time.Time{"connect": connected, "uplink": last},
+			counts:       map[string]float64{"uplink": 5},
+			disconnected: true,
+		},
+		{
+			name: "all messages",
+			stats: gwtest.GatewayConnectionStats(
+				gwtest.WithConnectedAt(connected),
+				gwtest.WithUplinks(5, last),
+				gwtest.WithDownlinks(3, last.Add(time.Second)),
+				gwtest.WithTxAcks(2, last.Add(2*time.Second)),
+			),
+			times: map[string]time.Time{
+				"connect":  connected,
+				"uplink":   last,
+				"downlink": last.Add(time.Second),
+				"txack":    last.Add(2 * time.Second),
+			},
+			counts: map[string]float64{"uplink": 5, "downlink": 3, "txack": 2},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gwid := "test-" + tc.name
+			defer deleteGatewayMetrics(gwid)
+
+			store := newMemoryStore("a", time.Minute, 0, clock)
+			updateGateway(store, clock, gwid, tc.stats, clock.Now(), true)
+
+			// The series are counted before WithLabelValues below, which
+			// would create missing ones.
+			if got := testutil.CollectAndCount(gatewayCollector{gwTime, gwid}); got != len(tc.times) {
+				t.Errorf("%d gateway_timestamp_seconds series, want %d", got, len(tc.times))
+			}
+			if got := testutil.CollectAndCount(gatewayCollector{gwCount, gwid}); got != len(tc.counts) {
+				t.Errorf("%d gateway_messages series, want %d", got, len(tc.counts))
+			}
+			for typ, want := range tc.times {
+				if got := testutil.ToFloat64(gwTime.WithLabelValues(gwid, typ)); got != float64(want.Unix()) {
+					t.Errorf("gateway_timestamp_seconds{type=%q} = %g, want %d", typ, got, want.Unix())
+				}
+			}
+			for typ, want := range tc.counts {
+				if got := testutil.ToFloat64(gwCount.WithLabelValues(gwid, typ)); got != want {
+					t.Errorf("gateway_messages{type=%q} = %g, want %g", typ, got, want)
+				}
+			}
+
+			wantConnected := 0.0
+			if _, ok := tc.times["connect"]; ok && !tc.disconnected {
+				wantConnected = 1
+			}
+			if got := testutil.ToFloat64(gwConnected.WithLabelValues(gwid)); got != wantConnected {
+				t.Errorf("gateway_connected = %g, want %g", got, wantConnected)
+			}
+		})
+	}
+}
+
+func TestUpdateGatewaySubBands(t *testing.T) {
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	const gwid = "test-subbands"
+	defer deleteGatewayMetrics(gwid)
+
+	stats := gwtest.GatewayConnectionStats(
+		gwtest.WithConnectedAt(clock.Now().Add(-time.Hour)),
+		gwtest.WithSubBand(863000000, 865000000, 0.0005, 0.001),
+		gwtest.WithSubBand(868000000, 868600000, 0.004, 0.01),
+	)
+	updateGateway(newMemoryStore("a", time.Minute, 0, clock), clock, gwid, stats, clock.Now(), true)
+
+	for _, tc := range []struct {
+		min, max    string
+		utilization float32
+		limit       float32
+	}{
+		{min: "863000000", max: "865000000", utilization: 0.0005, limit: 0.001},
+		{min: "868000000", max: "868600000", utilization: 0.004, limit: 0.01},
+	} {
+		if got := testutil.ToFloat64(gwSubBandUtilization.WithLabelValues(gwid, tc.min, tc.max)); got != float64(tc.utilization) {
+			t.Errorf("%s-%s: utilization = %g, want %g", tc.min, tc.max, got, tc.utilization)
+		}
+		if got := testutil.ToFloat64(gwSubBandUtilizationLimit.WithLabelValues(gwid, tc.min, tc.max)); got != float64(tc.limit) {
+			t.Errorf("%s-%s: limit = %g, want %g", tc.min, tc.max, got, tc.limit)
+		}
+	}
+}
+
+func TestCheckTimestamps(t *testing.T) {
+	defer func() { clampTimestamps = true }()
+	now := time.Unix(1700000000, 0)
+
+	for _, tc := range []struct {
+		name    string
+		uplink  time.Time
+		clamp   bool
+		want    time.Time
+		anomaly bool
+	}{
+		{name: "past", uplink: now.Add(-time.Second), clamp: true, want: now.Add(-time.Second)},
+		{name: "now", uplink: now, clamp: true, want: now},
+		{name: "just ahead", uplink: now.Add(time.Nanosecond), clamp: true, want: now, anomaly: true},
+		{name: "future", uplink: now.Add(time.Hour), clamp: true, want: now, anomaly: true},
+		{name: "future unclamped", uplink: now.Add(time.Hour), want: now.Add(time.Hour), anomaly: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clampTimestamps = tc.clamp
+			gwid := "test-" + tc.name
+			anomalies := gatewayClockAnomalies.WithLabelValues(gwid)
+			before := testutil.ToFloat64(anomalies)
+
+			gw := Gateway{id: gwid, connectTime: now.Add(-time.Hour), uplinkTime: tc.uplink}
+			checkTimestamps(&gw, now)
+
+			if !gw.uplinkTime.Equal(tc.want) {
+				t.Errorf("uplinkTime = %s, want %s", gw.uplinkTime, tc.want)
+			}
+			if !gw.connectTime.Equal(now.Add(-time.Hour)) {
+				t.Errorf("connectTime = %s, changed although it is in the past", gw.connectTime)
+			}
+			if got := testutil.ToFloat64(anomalies) - before; got != boolFloat(tc.anomaly) {
+				t.Errorf("anomalies increased by %g, want %g", got, boolFloat(tc.anomaly))
+			}
+		})
+	}
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestHandleUplinkOptions(t *testing.T) {
+	defer func() { uplinkPayloadMetric, bandwidthMetrics = false, false }()
+	const gwid = "test-uplink"
+
+	for _, tc := range []struct {
+		name      string
+		payload   bool
+		bandwidth bool
+	}{
+		{name: "none"},
+		{name: "payload", payload: true},
+		{name: "bandwidth", bandwidth: true},
+		{name: "both", payload: true, bandwidth: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			uplinkPayloadMetric, bandwidthMetrics = tc.payload, tc.bandwidth
+			clock := newFakeClock(time.Unix(1700000000, 0))
+			store := newMemoryStore("a", time.Minute, 0, clock)
+			store.Upsert(Gateway{id: gwid})
+			defer deleteGatewayMetrics(gwid)
+
+			msg := &ttnpb.GatewayUplinkMessage{Message: &ttnpb.UplinkMessage{RawPayload: make([]byte, 20)}}
+			handleUplink(gatewayEvent(evGatewayUplink, msg, gwid), store)
+
+			// Deleting reports whether the series existed.
+			if got := gwUplinkPayload.DeleteLabelValues(gwid); got != tc.payload {
+				t.Errorf("gateway_uplink_payload_bytes exists = %t, want %t", got, tc.payload)
+			}
+			want := uint64(0)
+			if tc.bandwidth {
+				want = 20
+			}
+			if gw, _ := store.Get(gwid); gw.uplinkBytes != want {
+				t.Errorf("uplinkBytes = %d, want %d", gw.uplinkBytes, want)
+			}
+		})
+	}
+}
+
+func TestEventGateways(t *testing.T) {
+	gw := func(id string) *ttnpb.EntityIdentifiers {
+		return (&ttnpb.GatewayIdentifiers{GatewayId: id}).GetEntityIdentifiers()
+	}
+	app := (&ttnpb.ApplicationIdentifiers{ApplicationId: "app"}).GetEntityIdentifiers()
+
+	for _, tc := range []struct {
+		name string
+		ids  []*ttnpb.EntityIdentifiers
+		want []string
+	}{
+		{name: "none"},
+		{name: "single", ids: []*ttnpb.EntityIdentifiers{gw("gw-1")}, want: []string{"gw-1"}},
+		{name: "order", ids: []*ttnpb.EntityIdentifiers{gw("gw-2"), gw("gw-1")}, want: []string{"gw-2", "gw-1"}},
+		{name: "duplicates", ids: []*ttnpb.EntityIdentifiers{gw("gw-1"), gw("gw-2"), gw("gw-1")}, want: []string{"gw-1", "gw-2"}},
+		{name: "empty ID", ids: []*ttnpb.EntityIdentifiers{gw(""), gw("gw-1")}, want: []string{"gw-1"}},
+		{name: "other entities", ids: []*ttnpb.EntityIdentifiers{app, nil, gw("gw-1")}, want: []string{"gw-1"}},
+	} {
+		got := eventGateways(&testEvent{ids: tc.ids})
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: eventGateways = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var lastErrorTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "lytgae_last_error_timestamp_seconds",
+	Help: "Time of the last API or stream error.",
+})
+
+// setError retains err as the last error of the client.
+func (c *Client) setError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastErr = err
+	c.lastErrTime = c.clock.Now()
+	lastErrorTimestamp.Set(float64(c.lastErrTime.Unix()))
+	if legacyLastErrorTimestamp != nil {
+		legacyLastErrorTimestamp.Set(float64(c.lastErrTime.Unix()))
+	}
+}
+
+// clearError forgets the last error after a successful operation.
+func (c *Client) clearError() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastErr = nil
+}
+
+func (c *Client) lastError() (error, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lastErr, c.lastErrTime
+}
+
+// healthz reports 503 with the retained errors if any client has one.
+func healthz(clients []*Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body string
+		for _, c := range clients {
+			if err, t := c.lastError(); err != nil {
+				body += fmt.Sprintf("%s: %v\n", t.Format(timeFmt), err)
+			}
+		}
+
+		if body != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, body)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const influxTimeout = 10 * time.Second
+
+var influxFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "lytgae_influx_write_failures_total",
+	Help: "Number of failed writes to InfluxDB.",
+})
+
+// influxWriter writes the gateways of the store to InfluxDB in line
+// protocol. url is the complete write endpoint, like
+// http://influxdb:8086/api/v2/write?org=o&bucket=b for InfluxDB 2 or
+// http://influxdb:8086/write?db=d for InfluxDB 1.
+type influxWriter struct {
+	url       string
+	token     string
+	batchSize int
+	client    *http.Client
+}
+
+func newInfluxWriter(cfg *Config) *influxWriter {
+	return &influxWriter{
+		url:       cfg.InfluxURL,
+		token:     cfg.InfluxToken,
+		batchSize: cfg.InfluxBatchSize,
+		client:    &http.Client{Timeout: influxTimeout},
+	}
+}
+
+// influxLoop writes the store to InfluxDB every interval until ctx is done.
+func influxLoop(ctx context.Context, w *influxWriter, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.write(ctx, store.Snapshot()); err != nil && ctx.Err() == nil {
+				log.Printf("influx: %v", err)
+			}
+		}
+	}
+}
+
+// write sends a point per gateway, batchSize points per request. A failed
+// batch does not stop the others, the first error is returned.
+func (w *influxWriter) write(ctx context.Context, gws []Gateway) error {
+	var rtn error
+	for len(gws) > 0 {
+		n := min(len(gws), w.batchSize)
+
+		var buf bytes.Buffer
+		for _, gw := range gws[:n] {
+			writeInfluxLine(&buf, gw)
+		}
+		if err := w.post(ctx, &buf); err != nil {
+			influxFailures.Inc()
+			if rtn == nil {
+				rtn = err
+			}
+		}
+		gws = gws[n:]
+	}
+
+	return rtn
+}
+
+func (w *influxWriter) post(ctx context.Context, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// writeInfluxLine writes the point of a gateway, timestamped with the time
+// of its last connection stats.
+func writeInfluxLine(buf *bytes.Buffer, gw Gateway) {
+	buf.WriteString("gateway,gateway=")
+	buf.WriteString(influxEscape(relabelGateway(gw.id)))
+	if gw.server != "" {
+		buf.WriteString(",server=")
+		buf.WriteString(influxEscape(gw.server))
+	}
+	if gw.protocol != "" {
+		buf.WriteString(",protocol=")
+		buf.WriteString(influxEscape(gw.protocol))
+	}
+
+	fmt.Fprintf(buf, " connected=%t", gw.connected())
+	fields := []struct {
+		name string
+		t    time.Time
+		n    uint64
+	}{
+		{"uplink", gw.uplinkTime, gw.uplinkCount},
+		{"downlink", gw.downlinkTime, gw.downlinkCount},
+		{"txack", gw.txAckTime, gw.txAckCount},
+	}
+	for _, f := range fields {
+		fmt.Fprintf(buf, ",%s_count=%di", f.name, f.n)
+		if f.n != 0 {
+			fmt.Fprintf(buf, ",%s_timestamp=%di", f.name, f.t.Unix())
+		}
+	}
+	if gw.connectTime.Unix() != 0 {
+		fmt.Fprintf(buf, ",connect_timestamp=%di", gw.connectTime.Unix())
+	}
+	if gw.statsInterval != 0 {
+		buf.WriteString(",stats_interval_seconds=")
+		buf.WriteString(strconv.FormatFloat(gw.statsInterval.Seconds(), 'f', -1, 64))
+	}
+	if gw.snrSamples != 0 {
+		buf.WriteString(",snr_avg=")
+		buf.WriteString(strconv.FormatFloat(gw.snrAvg, 'f', -1, 64))
+	}
+
+	fmt.Fprintf(buf, " %d\n", gw.lastSeen.UnixNano())
+}
+
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// influxEscape escapes a tag value for the line protocol.
+func influxEscape(s string) string {
+	return influxTagEscaper.Replace(s)
+}
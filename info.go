@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+)
+
+// gwInfoLabels are the labels of gateway_info that are always present. The
+// configured attribute labels follow them.
+var gwInfoLabels = []string{"gateway", "eui", "frequency_plan", "region"}
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+var (
+	gwInfo           *prometheus.GaugeVec
+	gwInfoAttributes []string
+)
+
+// initGatewayInfo registers gateway_info with an additional label for each
+// of the given gateway attributes. Only these attributes are exported to
+// keep the cardinality bounded.
+func initGatewayInfo(attrs []string) error {
+	labels := slices.Clone(gwInfoLabels)
+	for _, attr := range attrs {
+		if !labelNameRe.MatchString(attr) {
+			return fmt.Errorf("%q is not a valid label name", attr)
+		}
+		if slices.Contains(labels, attr) {
+			return fmt.Errorf("duplicate label %q", attr)
+		}
+		labels = append(labels, attr)
+	}
+
+	gwInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_info",
+		Help: "Always 1, labeled with the registry information of the gateway.",
+	}, labels)
+	gwInfoAttributes = attrs
+
+	return prometheus.Register(gwInfo)
+}
+
+// formatEUI returns the EUI in uppercase hex like the console shows it. The
+// empty string is returned for gateways without an EUI, which Prometheus
+// treats like a missing label.
+func formatEUI(eui []byte) string {
+	return fmt.Sprintf("%X", eui)
+}
+
+// publishGatewayInfo exports the registry information of a gateway.
+func publishGatewayInfo(gw *ttnpb.Gateway) {
+	fp := gw.GetFrequencyPlanId()
+	if fps := gw.GetFrequencyPlanIds(); len(fps) > 0 {
+		fp = fps[0]
+	}
+
+	values := []string{
+		relabelGateway(gw.IDString()),
+		formatEUI(gw.GetIds().GetEui()),
+		fp,
+		regionFromFrequencyPlan(fp),
+	}
+	for _, attr := range gwInfoAttributes {
+		values = append(values, sanitizeLabel(attr, gw.GetAttributes()[attr]))
+	}
+
+	gwInfo.WithLabelValues(values...).Set(1)
+	publishRegistryLocation(values[0], gw)
+}
@@ -0,0 +1,83 @@
+// Package testutil builds the stack's messages for the tests of lytgae.
+package testutil
+
+import (
+	"time"
+
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// StatsOption sets fields of the connection stats built by
+// GatewayConnectionStats.
+type StatsOption func(*ttnpb.GatewayConnectionStats)
+
+// GatewayConnectionStats returns the connection stats of a gateway with the
+// options applied. Without options, the gateway never connected.
+func GatewayConnectionStats(opts ...StatsOption) *ttnpb.GatewayConnectionStats {
+	stats := &ttnpb.GatewayConnectionStats{}
+	for _, opt := range opts {
+		opt(stats)
+	}
+
+	return stats
+}
+
+// WithConnectedAt sets the time the gateway connected.
+func WithConnectedAt(t time.Time) StatsOption {
+	return func(s *ttnpb.GatewayConnectionStats) {
+		s.ConnectedAt = timestamppb.New(t)
+	}
+}
+
+// WithDisconnectedAt sets the time the gateway disconnected.
+func WithDisconnectedAt(t time.Time) StatsOption {
+	return func(s *ttnpb.GatewayConnectionStats) {
+		s.DisconnectedAt = timestamppb.New(t)
+	}
+}
+
+// WithProtocol sets the protocol the gateway connected with.
+func WithProtocol(protocol string) StatsOption {
+	return func(s *ttnpb.GatewayConnectionStats) {
+		s.Protocol = protocol
+	}
+}
+
+// WithUplinks sets the number of uplinks and the time of the last one.
+func WithUplinks(n uint64, last time.Time) StatsOption {
+	return func(s *ttnpb.GatewayConnectionStats) {
+		s.UplinkCount = n
+		s.LastUplinkReceivedAt = timestamppb.New(last)
+	}
+}
+
+// WithDownlinks sets the number of downlinks and the time of the last one.
+func WithDownlinks(n uint64, last time.Time) StatsOption {
+	return func(s *ttnpb.GatewayConnectionStats) {
+		s.DownlinkCount = n
+		s.LastDownlinkReceivedAt = timestamppb.New(last)
+	}
+}
+
+// WithTxAcks sets the number of TX acknowledgments and the time of the
+// last one.
+func WithTxAcks(n uint64, last time.Time) StatsOption {
+	return func(s *ttnpb.GatewayConnectionStats) {
+		s.TxAcknowledgmentCount = n
+		s.LastTxAcknowledgmentReceivedAt = timestamppb.New(last)
+	}
+}
+
+// WithSubBand adds a sub-band from min to max Hz with its downlink
+// utilization and limit.
+func WithSubBand(min, max uint64, utilization, limit float32) StatsOption {
+	return func(s *ttnpb.GatewayConnectionStats) {
+		s.SubBands = append(s.SubBands, &ttnpb.GatewayConnectionStats_SubBand{
+			MinFrequency:             min,
+			MaxFrequency:             max,
+			DownlinkUtilization:      utilization,
+			DownlinkUtilizationLimit: limit,
+		})
+	}
+}
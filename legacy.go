@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The legacy metrics are only registered and updated with
+// LYTGAE_LEGACY_METRIC_NAMES, to give dashboards time to move to the new
+// names.
+var (
+	legacyGwTime             *prometheus.GaugeVec
+	legacyGwCount            *prometheus.GaugeVec
+	legacyLastErrorTimestamp prometheus.Gauge
+)
+
+func registerLegacyMetrics() {
+	legacyGwTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_time",
+		Help: "Deprecated, use gateway_timestamp_seconds.",
+	}, []string{"gateway", "type"})
+	legacyGwCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_count",
+		Help: "Deprecated, use gateway_messages.",
+	}, []string{"gateway", "type"})
+	legacyLastErrorTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lytgae_last_error_timestamp",
+		Help: "Deprecated, use lytgae_last_error_timestamp_seconds.",
+	})
+
+	prometheus.MustRegister(legacyGwTime, legacyGwCount, legacyLastErrorTimestamp)
+}
+
+func setGatewayTime(gwid, typ string, t time.Time) {
+	gwTime.WithLabelValues(gwid, typ).Set(float64(t.Unix()))
+	if legacyGwTime != nil {
+		legacyGwTime.WithLabelValues(gwid, typ).Set(float64(t.Unix()))
+	}
+}
+
+func setGatewayCount(gwid, typ string, n uint64) {
+	gwCount.WithLabelValues(gwid, typ).Set(float64(n))
+	if legacyGwCount != nil {
+		legacyGwCount.WithLabelValues(gwid, typ).Set(float64(n))
+	}
+}
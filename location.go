@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+)
+
+// locationMinMove is the distance in meters a GPS location has to move
+// before the series is updated, so GPS jitter doesn't create new series.
+const locationMinMove = 50
+
+var gwLocationInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "gateway_location_info",
+	Help: "Always 1, labeled with the location of the gateway and where it comes from.",
+}, []string{"gateway", "source", "latitude", "longitude", "altitude"})
+
+type gwLocation struct {
+	source    string
+	latitude  float64
+	longitude float64
+	altitude  int32
+}
+
+func (l gwLocation) labels(gwid string) []string {
+	return []string{
+		gwid,
+		l.source,
+		strconv.FormatFloat(l.latitude, 'f', 5, 64),
+		strconv.FormatFloat(l.longitude, 'f', 5, 64),
+		strconv.Itoa(int(l.altitude)),
+	}
+}
+
+// gwLocations holds the exported location of every gateway. A location
+// reported by the gateway in its status is preferred over the one set in
+// the registry.
+var gwLocations = struct {
+	mu      sync.Mutex
+	current map[string]gwLocation
+}{current: make(map[string]gwLocation)}
+
+// publishRegistryLocation exports the location of the first antenna, unless
+// the gateway already reported its own location.
+func publishRegistryLocation(gwid string, gw *ttnpb.Gateway) {
+	for _, antenna := range gw.GetAntennas() {
+		if loc := antenna.GetLocation(); loc != nil {
+			setLocation(gwid, newLocation("registry", loc), func(cur gwLocation) bool {
+				return cur.source != "gps"
+			})
+			return
+		}
+	}
+}
+
+// publishStatusLocation exports the location the gateway reported in its
+// status, if it moved significantly.
+func publishStatusLocation(gwid string, status *ttnpb.GatewayStatus) {
+	for _, loc := range status.GetAntennaLocations() {
+		if loc == nil {
+			continue
+		}
+		l := newLocation("gps", loc)
+		setLocation(gwid, l, func(cur gwLocation) bool {
+			return cur.source != "gps" || distance(cur, l) >= locationMinMove
+		})
+		return
+	}
+}
+
+func newLocation(source string, loc *ttnpb.Location) gwLocation {
+	return gwLocation{
+		source:    source,
+		latitude:  loc.GetLatitude(),
+		longitude: loc.GetLongitude(),
+		altitude:  loc.GetAltitude(),
+	}
+}
+
+// forgetLocation drops the exported location of a gateway, whose series
+// was deleted.
+func forgetLocation(gwid string) {
+	gwLocations.mu.Lock()
+	defer gwLocations.mu.Unlock()
+
+	delete(gwLocations.current, gwid)
+}
+
+// setLocation replaces the exported location of the gateway with l, if
+// there is none yet or replace returns true for the current one.
+func setLocation(gwid string, l gwLocation, replace func(cur gwLocation) bool) {
+	gwLocations.mu.Lock()
+	defer gwLocations.mu.Unlock()
+
+	cur, ok := gwLocations.current[gwid]
+	if ok {
+		if cur == l || !replace(cur) {
+			return
+		}
+		gwLocationInfo.DeleteLabelValues(cur.labels(gwid)...)
+	}
+
+	gwLocations.current[gwid] = l
+	gwLocationInfo.WithLabelValues(l.labels(gwid)...).Set(1)
+}
+
+// distance returns the great-circle distance between a and b in meters.
+func distance(a, b gwLocation) float64 {
+	const earthRadius = 6371000
+
+	lat1 := a.latitude * math.Pi / 180
+	lat2 := b.latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.longitude - a.longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
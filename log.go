@@ -0,0 +1,12 @@
+package main
+
+import "log"
+
+// debug enables debugf output, set with LYTGAE_DEBUG.
+var debug bool
+
+func debugf(format string, v ...any) {
+	if debug {
+		log.Printf(format, v...)
+	}
+}
@@ -5,45 +5,170 @@ import (
 	"crypto/tls"
 	"fmt"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
 	"go.thethings.network/lorawan-stack/v3/pkg/errors"
 	"go.thethings.network/lorawan-stack/v3/pkg/events"
 	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
-	"golang.org/x/exp/maps"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var (
 	gwTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "gateway_time",
+		Name: "gateway_timestamp_seconds",
+		Help: "Time of the last connect, uplink, downlink or TX acknowledgment of the gateway.",
 	}, []string{"gateway", "type"})
 	gwCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "gateway_count",
+		Name: "gateway_messages",
+		Help: "Number of uplinks, downlinks or TX acknowledgments of the gateway since it connected.",
 	}, []string{"gateway", "type"})
+	gwUplinkPayload = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "gateway_uplink_payload_bytes",
+		Help: "Size of the uplink PHYPayloads received by the gateway.",
+		// LoRaWAN PHYPayloads range from 12 bytes (empty frame) to 255 bytes.
+		Buckets: []float64{12, 16, 24, 32, 48, 64, 96, 128, 192, 255},
+	}, []string{"gateway"})
+	gwSNRAvg = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_snr_avg",
+		Help: "Exponential moving average of the SNR of the uplinks received by the gateway, in dB.",
+	}, []string{"gateway"})
+	gwConnected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_connected",
+		Help: "Whether the last connection stats show the gateway as connected.",
+	}, []string{"gateway"})
+	gwStatsInterval = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_stats_interval_seconds",
+		Help: "Estimated interval between connection stats events of the gateway.",
+	}, []string{"gateway"})
+	gwProtocolChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_protocol_changes_total",
+		Help: "Number of times the gateway connected with a different protocol.",
+	}, []string{"gateway"})
+	gwConnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_connects_total",
+		Help: "Number of times the connect time in the connection stats of the gateway changed.",
+	}, []string{"gateway"})
+	gwSubBandUtilization = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_subband_downlink_utilization",
+		Help: "Downlink duty cycle utilization of the sub-band.",
+	}, []string{"gateway", "min_frequency", "max_frequency"})
+	gwSubBandUtilizationLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_subband_downlink_utilization_limit",
+		Help: "Downlink duty cycle limit of the sub-band.",
+	}, []string{"gateway", "min_frequency", "max_frequency"})
+	lytgaeInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lytgae_info",
+		Help: "Always 1, labeled with the instance name and the primary server.",
+	}, []string{"lytgae_instance", "server"})
+	timeToFirstEvent = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "lytgae_time_to_first_event_seconds",
+		Help: "Time from startup until the first event was received.",
+	})
+	activeStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "lytgae_active_streams",
+		Help: "Number of event streams that are currently established.",
+	})
+	watchdogReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "lytgae_stream_watchdog_reconnects_total",
+		Help: "Number of reconnects because a stream was idle for too long.",
+	})
+	goAwayReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "lytgae_stream_goaway_reconnects_total",
+		Help: "Number of immediate reconnects because the server sent GOAWAY.",
+	})
+	sendBlocked = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "lytgae_stream_send_blocked_seconds_total",
+		Help: "Time the stream readers spent waiting for the event consumer.",
+	})
+	streamDowntime = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "lytgae_stream_downtime_seconds",
+		Help:    "Time from losing an event stream until it was reestablished.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+	gatewaysConfigured = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lytgae_gateways_configured",
+		Help: "Number of gateways configured or discovered for an API key.",
+	}, []string{"key"})
+	gatewaysSubscribed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lytgae_gateways_subscribed",
+		Help: "Number of gateways in the current event stream of an API key.",
+	}, []string{"key"})
+	streamIdentifiers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lytgae_stream_identifiers",
+		Help: "Number of gateway and application identifiers in the current event stream of an API key.",
+	}, []string{"key"})
 )
 
-const timeFmt = "2006-01-02 15:04:05"
+const (
+	timeFmt = "2006-01-02 15:04:05"
+
+	eventBufferSize = 64
+	sweepInterval   = 30 * time.Second
+	shutdownTimeout = 10 * time.Second
+)
 
 type Gateway struct {
 	id            string
+	lastSeen      time.Time
+	server        string
+	protocol      string
 	connectTime   time.Time
+	disconnTime   time.Time
 	uplinkTime    time.Time
 	uplinkCount   uint64
 	downlinkTime  time.Time
 	downlinkCount uint64
 	txAckTime     time.Time
 	txAckCount    uint64
+
+	// statsTime is the time of the last connection stats event and
+	// statsInterval the estimated interval between those events.
+	statsTime     time.Time
+	statsInterval time.Duration
+
+	// snrAvg is the moving average of the SNR of the uplinks received by
+	// the gateway, over snrSamples uplinks.
+	snrAvg     float64
+	snrSamples uint64
+
+	// uplinkBytes and downlinkBytes sum up the payload sizes of the
+	// uplink and downlink events of the gateway.
+	uplinkBytes   uint64
+	downlinkBytes uint64
+
+	// lastLogged is when a message about the gateway was last logged,
+	// see gwLogMinInterval. It is local to the instance.
+	lastLogged time.Time
+
+	// connectedAt is the connect time as reported, before checkTimestamps
+	// had a chance to clamp it. A change means the gateway reconnected.
+	connectedAt time.Time
+}
+
+// connected reports whether the last stats show the gateway as connected.
+func (g Gateway) connected() bool {
+	return g.connectTime.Unix() != 0 && !g.disconnTime.After(g.connectTime)
 }
 
 func (g Gateway) String() string {
@@ -51,212 +176,1058 @@ func (g Gateway) String() string {
 
 	if g.connectTime.Unix() != 0 {
 		parts = append(parts, fmt.Sprintf("connected: %s", g.connectTime.Format(timeFmt)))
-		gwTime.WithLabelValues(g.id, "connect").Set(float64(g.connectTime.Unix()))
 	}
 
 	if g.uplinkCount != 0 {
 		parts = append(parts, fmt.Sprintf("uplinks: %d (last %s)", g.uplinkCount, g.uplinkTime.Format(timeFmt)))
-		gwTime.WithLabelValues(g.id, "uplink").Set(float64(g.uplinkTime.Unix()))
-		gwCount.WithLabelValues(g.id, "uplink").Set(float64(g.uplinkCount))
 	}
 
 	if g.downlinkCount != 0 {
 		parts = append(parts, fmt.Sprintf("downlinks: %d (last %s)", g.downlinkCount, g.downlinkTime.Format(timeFmt)))
-		gwTime.WithLabelValues(g.id, "downlink").Set(float64(g.downlinkTime.Unix()))
-		gwCount.WithLabelValues(g.id, "downlink").Set(float64(g.downlinkCount))
 	}
 
 	if g.txAckCount != 0 {
 		parts = append(parts, fmt.Sprintf("txAck: %d (last %s)", g.txAckCount, g.txAckTime.Format(timeFmt)))
-		gwTime.WithLabelValues(g.id, "txack").Set(float64(g.txAckTime.Unix()))
-		gwCount.WithLabelValues(g.id, "txack").Set(float64(g.txAckCount))
 	}
 
 	return strings.Join(parts, " ")
 }
 
+// publish exports the times and counts of the gateway. Like in String,
+// counts of zero are skipped.
+func (g Gateway) publish() {
+	gwid := relabelGateway(g.id)
+
+	if g.connectTime.Unix() != 0 && metricTypeEnabled(g.id, "connect") {
+		setGatewayTime(gwid, "connect", g.connectTime)
+	}
+
+	if g.uplinkCount != 0 && metricTypeEnabled(g.id, "uplink") {
+		setGatewayTime(gwid, "uplink", g.uplinkTime)
+		setGatewayCount(gwid, "uplink", g.uplinkCount)
+	}
+
+	if g.downlinkCount != 0 && metricTypeEnabled(g.id, "downlink") {
+		setGatewayTime(gwid, "downlink", g.downlinkTime)
+		setGatewayCount(gwid, "downlink", g.downlinkCount)
+	}
+
+	if g.txAckCount != 0 && metricTypeEnabled(g.id, "txack") {
+		setGatewayTime(gwid, "txack", g.txAckTime)
+		setGatewayCount(gwid, "txack", g.txAckCount)
+	}
+
+	if g.snrSamples != 0 {
+		gwSNRAvg.WithLabelValues(gwid).Set(g.snrAvg)
+	}
+}
+
 type Client struct {
 	server string
 	apikey string
+	// name identifies the API key in debug logs without revealing it and
+	// key is its index in Config.APIKeys.
+	name string
+	key  int
+	// pattern is the gateway ID pattern of the API key and found the
+	// number of gateways listed or configured before applying it.
+	pattern string
+	found   int
+
+	gateways     []*ttnpb.EntityIdentifiers
+	applications []*ttnpb.EntityIdentifiers
+	// names limits the stream to these event names, all if empty.
+	names []string
+	// resubscribe is set when the gateways changed, the stream is then
+	// stopped and reconnected right away.
+	resubscribe  atomic.Bool
+	esc          *ttnpb.Events_StreamClient
+	streamHeader bool
+	active       bool
+	mu           sync.Mutex
+	cancelStream context.CancelFunc
+	ctx          context.Context
+	conn         *grpc.ClientConn
+
+	// servers are tried in order, conns holds a connection per server and
+	// conn is the one of the server in use, servers[current].
+	servers       []string
+	conns         []*grpc.ClientConn
+	current       int
+	failoverAfter int
+
+	// dial creates the connection to a server. The connection in use is
+	// replaced after connMaxAge, see recycleConns.
+	dial       func(server string) (*grpc.ClientConn, error)
+	connMaxAge time.Duration
+	recycled   chan *recycledStream
+	// recorder receives every event of the stream if set.
+	recorder *recorder
+
+	// connected is the time of the last stream connect and lastEvent the
+	// time of the last event per gateway ID.
+	connected time.Time
+	lastEvent map[string]time.Time
+
+	// tail and after are passed to the server to replay past events, see
+	// Config.StreamTail. lastEventTime is the time of the last received
+	// event, which later streams start after.
+	tail          uint32
+	after         time.Time
+	lastEventTime time.Time
+
+	lastErr     error
+	lastErrTime time.Time
 
-	gateways []*ttnpb.EntityIdentifiers
-	esc      *ttnpb.Events_StreamClient
-	ctx      context.Context
-	conn     *grpc.ClientConn
+	idleTimeout    time.Duration
+	maxRecvMsgSize int
+	gatewayCache   string
+
+	clock Clock
 }
 
-func NewClient(server string, apikey string, gateways []string) (*Client, error) {
+// NewClient returns a client for the gateways covered by cfg.APIKeys[key].
+// The applications are subscribed by the client of the last key.
+func NewClient(ctx context.Context, cfg *Config, key int) (*Client, error) {
+	creds := credentials.NewTLS(&tls.Config{})
+	if cfg.Plaintext {
+		// The API key is sent in the metadata, so it still reaches the
+		// server, in the clear.
+		creds = insecure.NewCredentials()
+	}
+
 	opts := []grpc.DialOption{
-		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})),
+		grpc.WithTransportCredentials(creds),
 		grpc.WithKeepaliveParams(keepalive.ClientParameters{
-			Time:    10 * time.Second,
-			Timeout: time.Second,
+			Time:    cfg.KeepaliveTime,
+			Timeout: cfg.KeepaliveTimeout,
 		}),
 	}
 
+	if cfg.RetryMaxAttempts > 1 {
+		r := newRetrier(cfg.RetryMaxAttempts)
+		opts = append(opts,
+			grpc.WithUnaryInterceptor(r.unary),
+			grpc.WithStreamInterceptor(r.stream),
+		)
+	}
+
+	proxied := false
+	if cfg.GRPCProxy != "" {
+		dialer, err := connectDialer(cfg.GRPCProxy)
+		if err != nil {
+			return nil, fmt.Errorf("LYTGAE_GRPC_PROXY: %v", err)
+		}
+		opts = append(opts, grpc.WithContextDialer(dialer))
+		proxied = true
+	}
+
+	apikey := cfg.APIKeys[key].Key
 	md := metadata.Pairs("authorization", "Bearer "+apikey)
-	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	ctx = metadata.NewOutgoingContext(ctx, md)
 
-	conn, err := grpc.NewClient(server, opts...)
-	if err != nil {
-		return nil, fmt.Errorf("NewClient: %v", err)
+	dial := func(server string) (*grpc.ClientConn, error) {
+		target := server
+		if proxied {
+			// Let the proxy resolve the server name, the local network
+			// might not be able to.
+			target = "passthrough:///" + server
+		}
+		conn, err := grpc.NewClient(target, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("NewClient %s: %v", server, err)
+		}
+		return conn, nil
+	}
+
+	var conns []*grpc.ClientConn
+	for _, server := range cfg.Servers {
+		conn, err := dial(server)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, conn)
 	}
 
 	client := &Client{
-		server: server,
+		server: cfg.Server,
 		apikey: apikey,
+		name:   keyName(apikey, key),
+		key:    key,
 		ctx:    ctx,
-		conn:   conn,
+		conn:   conns[0],
+
+		pattern: cfg.APIKeys[key].Pattern,
+
+		gatewayCache:  cfg.GatewayCache,
+		servers:       cfg.Servers,
+		conns:         conns,
+		failoverAfter: cfg.FailoverAfter,
+		dial:          dial,
+		connMaxAge:    cfg.ConnMaxAge,
+		recycled:      make(chan *recycledStream, 1),
+
+		names:     cfg.StreamNames,
+		lastEvent: make(map[string]time.Time),
+
+		tail:  cfg.StreamTail,
+		after: cfg.StreamAfter,
+
+		idleTimeout:    cfg.StreamIdleTimeout,
+		maxRecvMsgSize: cfg.GRPCMaxRecv,
+
+		clock: realClock{},
+	}
+
+	keep := func(gwid string) bool {
+		return cfg.keyIndex(gwid) == key
 	}
 
-	if len(gateways) == 0 {
-		gateways, err := client.getGateways()
+	client.useServer(0)
+
+	if len(cfg.Gateways) == 0 {
+		// Fall back to the next server if the list can not be fetched.
+		var err error
+		for i := range client.servers {
+			client.useServer(i)
+			client.gateways, err = client.getGateways(keep)
+			if err == nil {
+				break
+			}
+			log.Printf("getGateways on %s: %v", client.server, err)
+		}
+		if err != nil && cfg.GatewayCache != "" {
+			gws, t, cerr := readGatewayCache(cfg.GatewayCache, client.name)
+			if cerr == nil {
+				log.Printf("getGateways: %v, using %d cached gateways from %s", err, len(gws), t.Format(timeFmt))
+				if time.Since(t) > gatewayCacheStale {
+					log.Printf("Warning: the gateway cache is older than %s", gatewayCacheStale)
+				}
+				client.gateways, err = useGateways(gws), nil
+				client.found = len(gws)
+			} else {
+				log.Printf("gateway cache: %v", cerr)
+			}
+		}
 		if err != nil {
-			return nil, fmt.Errorf("getGateways: %v", err)
+			return nil, fmt.Errorf("getGateways: %w", err)
 		}
-		client.gateways = gateways
 	} else {
+		gateways, err := client.resolveGateways(cfg.Gateways)
+		if err != nil {
+			return nil, fmt.Errorf("LYTGAE_GW: %w", err)
+		}
+		client.found = len(gateways)
 		for _, gw := range gateways {
+			if !keep(gw) {
+				continue
+			}
 			client.gateways = append(client.gateways, (&ttnpb.GatewayIdentifiers{GatewayId: gw}).GetEntityIdentifiers())
 		}
 	}
 
+	if key == len(cfg.APIKeys)-1 {
+		for _, app := range cfg.Applications {
+			client.applications = append(client.applications, (&ttnpb.ApplicationIdentifiers{ApplicationId: app}).GetEntityIdentifiers())
+		}
+	}
+
+	debugf("key %s: %d gateways, %d applications", client.name, len(client.gateways), len(client.applications))
+	gatewaysConfigured.WithLabelValues(client.name).Set(float64(len(client.gateways)))
+
 	return client, nil
 }
 
+func (c *Client) markEvent(ev events.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ev.Time().After(c.lastEventTime) {
+		c.lastEventTime = ev.Time()
+	}
+	for _, gwid := range eventGateways(ev) {
+		c.lastEvent[gwid] = c.clock.Now()
+	}
+}
+
+// gatewaysWithoutEvents returns the number of subscribed gateways that did
+// not produce an event since the stream was last connected.
+func (c *Client) gatewaysWithoutEvents() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, id := range c.gateways {
+		if c.lastEvent[id.GetGatewayIds().GetGatewayId()].Before(c.connected) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// keyName returns the ID part of an API key (NNSXS.<ID>.<secret>), or the
+// index of the key if it is not in that format.
+func keyName(apikey string, idx int) string {
+	parts := strings.Split(apikey, ".")
+	if len(parts) == 3 {
+		return parts[1]
+	}
+
+	return fmt.Sprintf("#%d", idx)
+}
+
+// streamIdentifiers returns the identifiers to subscribe to, or nil if the
+// client has nothing to stream.
+func (c *Client) streamIdentifiers() []*ttnpb.EntityIdentifiers {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.gateways) == 0 && len(c.applications) == 0 {
+		return nil
+	}
+
+	return append(slices.Clone(c.gateways), c.applications...)
+}
+
 func (c *Client) Close() error {
-	return c.conn.Close()
+	var rtn error
+	for _, conn := range c.conns {
+		if err := conn.Close(); err != nil {
+			rtn = err
+		}
+	}
+
+	return rtn
 }
 
-func (c *Client) getGateways() ([]*ttnpb.EntityIdentifiers, error) {
+// getGateways lists all gateways visible to the API key for which keep
+// returns true.
+func (c *Client) getGateways(keep func(gwid string) bool) ([]*ttnpb.EntityIdentifiers, error) {
 	rtn := []*ttnpb.EntityIdentifiers{}
 	log.Printf("Get gateways")
 
-	req := &ttnpb.ListGatewaysRequest{}
-	gws, err := ttnpb.NewGatewayRegistryClient(c.conn).List(c.ctx, req)
+	req := &ttnpb.ListGatewaysRequest{
+		FieldMask: &fieldmaskpb.FieldMask{
+			Paths: []string{"frequency_plan_ids", "attributes", "antennas"},
+		},
+	}
+	var header, trailer metadata.MD
+	gws, err := ttnpb.NewGatewayRegistryClient(c.conn).List(c.ctx, req, grpc.Header(&header), grpc.Trailer(&trailer))
+	recordRateLimit("GatewayRegistry.List", header, trailer)
 	if err != nil {
-		return rtn, fmt.Errorf("list gateways: %v", err)
+		c.setError(fmt.Errorf("list gateways: %v", err))
+		return rtn, newClientError("list gateways", err, ErrDiscovery)
 	}
+	c.clearError()
+	c.found = len(gws.GetGateways())
 
+	var kept []*ttnpb.Gateway
 	for _, gw := range gws.GetGateways() {
+		if !keep(gw.IDString()) {
+			debugf("key %s: skip gateway %s, it belongs to another key", c.name, gw.IDString())
+			continue
+		}
+		kept = append(kept, gw)
+	}
+
+	if c.gatewayCache != "" {
+		if err := writeGatewayCache(c.gatewayCache, c.name, kept); err != nil {
+			log.Printf("gateway cache: %v", err)
+		}
+	}
+
+	return append(rtn, useGateways(kept)...), nil
+}
+
+// useGateways publishes the registry information of the gateways and
+// returns their identifiers.
+func useGateways(gws []*ttnpb.Gateway) []*ttnpb.EntityIdentifiers {
+	var rtn []*ttnpb.EntityIdentifiers
+	for _, gw := range gws {
 		log.Printf("Found gateway %s", gw.IDString())
 		rtn = append(rtn, gw.Ids.GetEntityIdentifiers())
+		publishGatewayInfo(gw)
 	}
 
-	return rtn, nil
+	return rtn
 }
 
-func (c *Client) connectEventstream() error {
-	client := ttnpb.NewEventsClient(c.conn)
+func (c *Client) streamRequest() *ttnpb.StreamEventsRequest {
 	req := &ttnpb.StreamEventsRequest{
-		Identifiers: c.gateways,
+		Identifiers: c.streamIdentifiers(),
+		Names:       c.names,
+		Tail:        c.tail,
+	}
+	if after := c.streamAfter(); !after.IsZero() {
+		req.After = timestamppb.New(after)
+	}
+
+	return req
+}
+
+// streamAfter returns the time from which the server should replay events,
+// zero for none. Once events were received, replays start after the last
+// of them, so a reconnect only asks for the events that were missed.
+func (c *Client) streamAfter() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tail == 0 && c.after.IsZero() {
+		return time.Time{}
+	}
+	if c.lastEventTime.After(c.after) {
+		return c.lastEventTime
+	}
+
+	return c.after
+}
+
+// emptyReason explains why the client has no gateways.
+func (c *Client) emptyReason() string {
+	if c.found == 0 {
+		return "the API key can not see any gateways, check its rights"
+	}
+
+	return fmt.Sprintf("none of the %d gateways match the pattern %q", c.found, c.pattern)
+}
+
+// countGateways returns the number of gateways among the identifiers.
+func countGateways(ids []*ttnpb.EntityIdentifiers) int {
+	n := 0
+	for _, id := range ids {
+		if id.GetGatewayIds() != nil {
+			n++
+		}
+	}
+
+	return n
+}
+
+// syncConnectionStats fetches the current connection stats of every gateway
+// from the Gateway Server and passes them to update. Gateways that are not
+// connected have no stats and are skipped.
+func (c *Client) syncConnectionStats(update func(gwid string, stats *ttnpb.GatewayConnectionStats)) {
+	c.mu.Lock()
+	gateways := slices.Clone(c.gateways)
+	c.mu.Unlock()
+
+	gs := ttnpb.NewGsClient(c.conn)
+	for _, id := range gateways {
+		gwid := id.GetGatewayIds().GetGatewayId()
+		stats, err := gs.GetGatewayConnectionStats(c.ctx, id.GetGatewayIds())
+		if err != nil {
+			if errors.IsNotFound(err) {
+				debugf("Gateway %s is not connected", gwid)
+			} else {
+				log.Printf("GetGatewayConnectionStats %s: %v", gwid, err)
+			}
+			continue
+		}
+		update(gwid, stats)
 	}
-	esc, err := client.Stream(c.ctx, req)
+}
+
+// stopStream cancels the current event stream, if any.
+func (c *Client) stopStream() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cancelStream != nil {
+		c.cancelStream()
+	}
+}
+
+// setActive keeps lytgae_active_streams in sync with the stream state.
+func (c *Client) setActive(active bool) {
+	if c.active == active {
+		return
+	}
+	c.active = active
+
+	if active {
+		activeStreams.Inc()
+	} else {
+		activeStreams.Dec()
+	}
+}
+
+// streamCallOptions are the call options of every Stream call. The
+// default receive limit of 4 MiB is too small for the connection stats of
+// large gateways.
+func (c *Client) streamCallOptions() []grpc.CallOption {
+	return []grpc.CallOption{grpc.MaxCallRecvMsgSize(c.maxRecvMsgSize)}
+}
+
+func (c *Client) connectEventstream() error {
+	c.stopStream()
+	ctx, cancel := context.WithCancel(c.ctx)
+
+	client := ttnpb.NewEventsClient(c.conn)
+	req := c.streamRequest()
+	esc, err := client.Stream(ctx, req, c.streamCallOptions()...)
 	if err != nil {
-		return err
+		cancel()
+		gatewaysSubscribed.WithLabelValues(c.name).Set(0)
+		streamIdentifiers.WithLabelValues(c.name).Set(0)
+		c.setError(fmt.Errorf("stream: %v", err))
+		return newClientError("stream", err)
 	}
+	c.clearError()
 
 	c.esc = &esc
+	c.streamHeader = true
+	c.mu.Lock()
+	c.cancelStream = cancel
+	c.connected = c.clock.Now()
+	c.mu.Unlock()
+	c.setActive(true)
+	gatewaysSubscribed.WithLabelValues(c.name).Set(float64(countGateways(req.Identifiers)))
+	streamIdentifiers.WithLabelValues(c.name).Set(float64(len(req.Identifiers)))
 
 	return nil
 }
 
+// getEvents forwards all received events to ec until the client context is
+// done or the stream fails.
 func (c *Client) getEvents(ec chan<- events.Event) error {
-	err := c.connectEventstream()
+	defer c.stopStream()
+	defer c.setActive(false)
+
+	err := c.reconnect()
 	if err != nil {
-		return fmt.Errorf("connectEventstream: %v", err)
+		return fmt.Errorf("connectEventstream: %w", err)
+	}
+
+	// The watchdog cancels the current stream if it did not deliver an
+	// event within idleTimeout, which makes Recv return below.
+	var idle atomic.Bool
+	resetWatchdog := func() {}
+	if c.idleTimeout > 0 {
+		watchdog := time.AfterFunc(c.idleTimeout, func() {
+			idle.Store(true)
+			c.stopStream()
+		})
+		defer watchdog.Stop()
+		resetWatchdog = func() { watchdog.Reset(c.idleTimeout) }
+	}
+
+	var failback atomic.Bool
+	if len(c.conns) > 1 {
+		done := make(chan struct{})
+		defer close(done)
+		spawn(func() { c.watchPrimary(done, &failback) })
+	}
+
+	var recent *recentEvents
+	if c.connMaxAge > 0 {
+		recent = newRecentEvents(recycleOverlapEvents)
+		done := make(chan struct{})
+		defer close(done)
+		spawn(func() { c.recycleConns(done) })
 	}
 
 	for {
 		pEvent, err := (*c.esc).Recv()
 		if err != nil {
-			if errors.IsCanceled(err) {
+			c.setActive(false)
+			if c.ctx.Err() != nil {
+				return nil
+			}
+			select {
+			case rs := <-c.recycled:
+				// The stream was stopped in favor of the one on the
+				// new connection, which is already running.
+				if c.useRecycled(rs) {
+					resetWatchdog()
+					recent.overlap = true
+					continue
+				}
+			default:
+			}
+			if c.resubscribe.Swap(false) {
+				err := c.reconnect()
+				if err != nil {
+					return fmt.Errorf("during reconnect: %w", err)
+				}
+				resetWatchdog()
 				continue
 			}
-			if errors.IsUnavailable(err) {
+			c.setError(fmt.Errorf("recv: %v", err))
+			disconnected := c.clock.Now()
+			if failback.Swap(false) {
+				log.Printf("%s is reachable again, switching back", c.servers[0])
+				c.useServer(0)
+				err := c.reconnect()
+				if err != nil {
+					return fmt.Errorf("during reconnect: %w", err)
+				}
+				streamDowntime.Observe(c.clock.Now().Sub(disconnected).Seconds())
+				resetWatchdog()
+				continue
+			}
+			if idle.Swap(false) {
+				log.Printf("No events for %s, reconnecting", c.idleTimeout)
+				watchdogReconnects.Inc()
+				err := c.reconnect()
+				if err != nil {
+					return fmt.Errorf("during reconnect: %w", err)
+				}
+				streamDowntime.Observe(c.clock.Now().Sub(disconnected).Seconds())
+				resetWatchdog()
+				continue
+			}
+			if isGoAway(err) {
+				// Skip the delay, if this fails the regular reconnect
+				// below takes over.
+				log.Printf("Server is going away, reconnecting")
+				goAwayReconnects.Inc()
+				if rerr := c.connectEventstream(); rerr == nil {
+					streamDowntime.Observe(c.clock.Now().Sub(disconnected).Seconds())
+					resetWatchdog()
+					continue
+				}
+			}
+			if errors.IsUnavailable(err) || errors.IsCanceled(err) {
 				log.Printf("Lost connection, trying to reconnect")
-				time.Sleep(5 * time.Second)
-				err := c.connectEventstream()
+				select {
+				case <-c.clock.After(5 * time.Second):
+				case <-c.ctx.Done():
+					return nil
+				}
+				err := c.reconnect()
 				if err != nil {
-					return fmt.Errorf("during reconnect: %v", err)
+					return fmt.Errorf("during reconnect: %w", err)
 				}
+				streamDowntime.Observe(c.clock.Now().Sub(disconnected).Seconds())
+				continue
+			}
+			return newClientError("recv", err)
+		}
+
+		resetWatchdog()
+
+		if recent != nil && recent.duplicate(pEvent.GetUniqueId()) {
+			recycleDuplicates.Inc()
+			continue
+		}
+
+		// The headers of the stream are available once the first
+		// event was received.
+		if c.streamHeader {
+			c.streamHeader = false
+			if md, err := (*c.esc).Header(); err == nil {
+				recordRateLimit("Events.Stream", md)
+			}
+		}
+
+		if c.recorder != nil {
+			if err := c.recorder.write(pEvent); err != nil {
+				log.Printf("record: %v", err)
 			}
-			return fmt.Errorf("recv: %v", err)
 		}
 
 		eEvent, err := events.FromProto(pEvent)
 		if err != nil {
+			c.setError(fmt.Errorf("FromProto: %v", err))
 			return fmt.Errorf("FromProto: %v", err)
 		}
 
-		ec <- eEvent
+		c.markEvent(eEvent)
+		queueTimes.push(c.clock.Now())
+		select {
+		case ec <- eEvent:
+		default:
+			// The consumer is behind and the buffer full.
+			start := c.clock.Now()
+			ec <- eEvent
+			sendBlocked.Add(c.clock.Now().Sub(start).Seconds())
+		}
 	}
 }
 
 func main() {
-	apikey, ok := os.LookupEnv("LYTGAE_APIKEY")
-	if !ok {
+	clock := realClock{}
+	start := clock.Now()
+
+	cfg, err := configFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	debug = cfg.Debug
+	lytgaeInfo.WithLabelValues(cfg.Instance, cfg.Server).Set(1)
+	publishThresholds(cfg)
+	clampTimestamps = cfg.ClampTimestamps
+	snrSmoothing = cfg.SNRSmoothing
+	gwLogMinInterval = cfg.GatewayLogMinInterval
+	maxLabelLength = cfg.MaxLabelLength
+	exemplars = cfg.OpenMetrics
+	precreateSeries = cfg.PrecreateSeries
+	uplinkPayloadMetric = cfg.UplinkPayloadMetric
+	bandwidthMetrics = cfg.BandwidthMetrics
+	if cfg.LegacyMetricNames {
+		registerLegacyMetrics()
+	}
+	if cfg.DisableDefaultCollectors {
+		// The default registry comes with these, equal collectors
+		// unregister them.
+		prometheus.Unregister(collectors.NewGoCollector())
+		prometheus.Unregister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+	if err := setGatewayRelabel(cfg.GatewayRelabel); err != nil {
+		log.Fatalf("LYTGAE_GW_RELABEL: %v", err)
+	}
+	if err := setGatewayGroups(cfg.GatewayGroups, cfg.GroupTypes); err != nil {
+		log.Fatalf("LYTGAE_GW_GROUPS/LYTGAE_GROUP_TYPES: %v", err)
+	}
+	if err := initGatewayInfo(cfg.GatewayAttributes); err != nil {
+		log.Fatalf("LYTGAE_GW_ATTRIBUTES: %v", err)
+	}
+	if err := initFirmwareInfo(cfg.FirmwareInfoKeys); err != nil {
+		log.Fatalf("LYTGAE_FIRMWARE_INFO_KEYS: %v", err)
+	}
+	if cfg.BatteryVoltsKeys != nil {
+		batteryVoltsKeys = cfg.BatteryVoltsKeys
+	}
+	if cfg.BatteryPercentKeys != nil {
+		batteryPercentKeys = cfg.BatteryPercentKeys
+	}
+	if cfg.TemperatureKeys != nil {
+		temperatureKeys = cfg.TemperatureKeys
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "scrape-config":
+			fmt.Print(scrapeConfig(cfg))
+		case "rules":
+			fmt.Print(rules(cfg))
+		case "check-key":
+			if err := checkKeys(context.Background(), cfg, os.Stdout); err != nil {
+				log.Fatalf("check-key: %v", err)
+			}
+		case "record":
+			if err := runRecord(cfg, os.Args[2:]); err != nil {
+				log.Fatalf("record: %v", err)
+			}
+		default:
+			log.Fatalf("unknown command %q", os.Args[1])
+		}
+		return
+	}
+
+	warnUnknownEventNames(cfg.StreamNames)
+	if cfg.Plaintext {
+		log.Printf("WARNING: LYTGAE_PLAINTEXT is set, the API keys are sent unencrypted. Only use this with a local development server.")
+	}
+
+	if len(cfg.APIKeys) == 0 && cfg.ReplayFile == "" {
 		log.Fatalf("LYTGAE_APIKEY is not set")
 	}
 
-	server, ok := os.LookupEnv("LYTGAE_SERVER")
-	if !ok {
-		log.Printf("LYTGAE_SERVER is not set, fallback to eu1.cloud.thethings.network:8884")
-		server = "eu1.cloud.thethings.network:8884"
+	if cfg.TLSCert != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey); err != nil {
+			log.Fatalf("LoadX509KeyPair: %v", err)
+		}
 	}
 
-	var gws []string
-	if egws, ok := os.LookupEnv("LYTGAE_GW"); ok {
-		gws = strings.Split(egws, ",")
+	rep, err := newReporter(cfg.SentryDSN, clock)
+	if err != nil {
+		log.Fatalf("LYTGAE_SENTRY_DSN: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.StartupJitter > 0 {
+		// Spread the initial API requests of instances restarted together.
+		d := time.Duration(rand.Int63n(int64(cfg.StartupJitter)))
+		log.Printf("Waiting %s before startup", d.Round(time.Millisecond))
+		select {
+		case <-clock.After(d):
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	var rec *recorder
+	if cfg.RecordFile != "" {
+		rec, err = newRecorder(cfg.RecordFile, int64(cfg.RecordMaxSize))
+		if err != nil {
+			log.Fatalf("LYTGAE_RECORD_FILE: %v", err)
+		}
 	}
 
-	c, err := NewClient(server, apikey, gws)
+	var clients []*Client
+	for i := range cfg.APIKeys {
+		if cfg.ReplayFile != "" {
+			// The recorded events replace the streams.
+			break
+		}
+		c, err := NewClient(ctx, cfg, i)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer c.Close()
+		c.recorder = rec
+
+		if c.streamIdentifiers() == nil {
+			log.Printf("key %s: nothing to subscribe to, %s", c.name, c.emptyReason())
+			continue
+		}
+		clients = append(clients, c)
+		for _, id := range c.gateways {
+			precreateGatewaySeries(id.GetGatewayIds().GetGatewayId())
+		}
+	}
+	if len(clients) == 0 && cfg.ReplayFile == "" {
+		if cfg.RequireGateways {
+			log.Fatalf("No API key has gateways to watch, set LYTGAE_REQUIRE_GATEWAYS=false to start anyway")
+		}
+		log.Printf("Warning: no API key has gateways to watch, no gateway metrics will be exported")
+	}
+
+	store, err := newStore(cfg, clock)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("newStore: %v", err)
 	}
-	defer c.Close()
 
-	gateways := make(map[string]*Gateway)
-	ch := make(chan events.Event)
-	go c.getEvents(ch)
+	// In poll mode the first poll does the same right away.
+	if cfg.InitialSync && cfg.Mode != modePoll {
+		for _, c := range clients {
+			c.syncConnectionStats(func(gwid string, stats *ttnpb.GatewayConnectionStats) {
+				updateGateway(store, clock, gwid, stats, clock.Now(), false)
+			})
+		}
+	}
 
-	go func() {
-		for ev := range ch {
-			if ev.Name() != "gs.gateway.connection.stats" {
-				continue
-			}
+	prometheus.MustRegister(ageCollector{store: store, clock: clock})
+	prometheus.MustRegister(muteCollector{store: store})
 
-			data, ok := ev.Data().(*ttnpb.GatewayConnectionStats)
-			if !ok {
-				log.Printf("event data seems to be of type %T", ev.Data())
-				continue
+	if cfg.BandwidthMetrics {
+		prometheus.MustRegister(bandwidthCollector{store: store})
+	}
+
+	if cfg.FleetMetrics {
+		prometheus.MustRegister(fleetCollector{store: store, legacy: cfg.LegacyMetricNames})
+	}
+
+	appGateways := newAppGateways(cfg.AppGatewayWindow, clock)
+	prometheus.MustRegister(appGateways)
+	appLabels := &appLabels{allow: cfg.AppUplinksAllow, max: cfg.AppUplinksMax}
+
+	acks := newDownlinkAcks(cfg.DownlinkAckTimeout)
+
+	d, err := newDispatcher(cfg.Events, map[string]eventHandler{
+		evConnectionStats: func(ev events.Event) { handleConnectionStats(ev, store, clock) },
+		evGatewayUplink:   func(ev events.Event) { handleUplink(ev, store) },
+		evApplicationUp:   func(ev events.Event) { handleApplicationUplink(ev, appGateways, appLabels) },
+		evGatewayAdmin:    func(ev events.Event) { handleGatewayAdmin(ev, store, clock) },
+		evGatewayStatus:   handleStatus,
+		evDownlinkSend: func(ev events.Event) {
+			acks.handleSend(ev)
+			handleDownlinkBytes(ev, store)
+		},
+		evDownlinkTxSuccess:    acks.handleAck,
+		evDownlinkScheduleFail: handleScheduleFailure,
+		evDownlinkTxFail: func(ev events.Event) {
+			acks.handleAck(ev)
+			handleDownlinkFailure(ev)
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Every key has its own stream, which reconnects on its own. The event
+	// channel is closed once all of them have stopped.
+	ch := make(chan events.Event, eventBufferSize)
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		c := c
+		wg.Add(1)
+		if cfg.Mode == modePoll {
+			spawn(func() {
+				defer wg.Done()
+				supervise(ctx, clock, "poll", rep, func() error {
+					return c.pollConnectionStats(cfg.PollInterval, store)
+				})
+			})
+			continue
+		}
+		spawn(func() {
+			defer wg.Done()
+			err := supervise(ctx, clock, "getEvents", rep, func() error {
+				return c.getEvents(ch)
+			})
+			if err != nil {
+				log.Printf("getEvents: %v", err)
+				debugf("key %s: stream stopped", c.name)
+			}
+		})
+	}
+	if cfg.ReplayFile != "" {
+		wg.Add(1)
+		spawn(func() {
+			defer wg.Done()
+			if err := replay(ctx, cfg.ReplayFile, cfg.ReplaySpeed, ch); err != nil {
+				log.Printf("replay: %v", err)
 			}
+			// Keep serving the replayed state until shutdown.
+			<-ctx.Done()
+		})
+	}
+	spawn(func() {
+		wg.Wait()
+		close(ch)
+		stop()
+	})
 
-			for _, id := range ev.Identifiers() {
-				gwid := id.GetGatewayIds().GetGatewayId()
-
-				gw := &Gateway{
-					id:            gwid,
-					connectTime:   data.GetConnectedAt().AsTime(),
-					uplinkCount:   data.GetUplinkCount(),
-					downlinkCount: data.GetDownlinkCount(),
-					txAckCount:    data.GetTxAcknowledgmentCount(),
-					uplinkTime:    data.GetLastUplinkReceivedAt().AsTime(),
-					downlinkTime:  data.GetLastDownlinkReceivedAt().AsTime(),
-					txAckTime:     data.GetLastTxAcknowledgmentReceivedAt().AsTime(),
-				}
+	spawn(func() { sampleQueueAge(ctx, clock, time.Second) })
+	spawn(func() { sweep(ctx, clock, clients, sweepInterval) })
+
+	if cfg.GatewayFile != "" {
+		spawn(func() { watchGatewayFile(ctx, clock, cfg, clients, store) })
+	}
+
+	spawn(func() { logTableOnSignal(ctx, store) })
 
-				gateways[gwid] = gw
+	if cfg.LogSummaryInterval > 0 {
+		spawn(func() { logSummary(ctx, store, cfg.LogSummaryInterval) })
+	}
+
+	var pusher *push.Pusher
+	if cfg.PushgatewayURL != "" {
+		pusher = newPusher(cfg)
+		spawn(func() { pushLoop(ctx, clock, pusher, cfg.PushInterval) })
+	}
+
+	var statsd *statsdWriter
+	if cfg.StatsdAddr != "" {
+		statsd, err = newStatsdWriter(cfg)
+		if err != nil {
+			log.Fatalf("LYTGAE_STATSD_ADDR: %v", err)
+		}
+		defer statsd.Close()
+		spawn(func() { statsdLoop(ctx, statsd, store, cfg.StatsdInterval) })
+	}
+
+	var influx *influxWriter
+	if cfg.InfluxURL != "" {
+		influx = newInfluxWriter(cfg)
+		spawn(func() { influxLoop(ctx, influx, store, cfg.InfluxInterval) })
+	}
+
+	done := make(chan struct{})
+	spawn(func() {
+		defer close(done)
+		var firstEvent sync.Once
+		supervise(ctx, clock, "consumer", rep, func() error {
+			for ev := range ch {
+				queueTimes.pop()
+				firstEvent.Do(func() {
+					timeToFirstEvent.Set(clock.Now().Sub(start).Seconds())
+				})
+				d.dispatch(ev)
 			}
+			return nil
+		})
+	})
 
-			k := maps.Keys[map[string]*Gateway](gateways)
-			slices.Sort[[]string](k)
-			for _, g := range k {
-				log.Printf("Gateway %s", gateways[g])
+	metrics := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: cfg.OpenMetrics}))
+	http.Handle("/metrics", bearerAuth(cfg.MetricsToken, metrics))
+	http.Handle("/healthz", healthz(clients))
+	if cfg.ConfigEndpoint != configEndpointOff {
+		http.Handle("/config", bearerAuth(cfg.MetricsToken, configHandler(cfg, cfg.ConfigEndpoint)))
+	}
+	if cfg.MuteEndpoint != configEndpointOff {
+		http.Handle("/gateways/", bearerAuth(cfg.MetricsToken, muteHandler(store, cfg.MuteEndpoint)))
+	}
+	// All addresses are bound before serving any of them, so a typo in one
+	// of them does not leave a half working exporter.
+	var lns []net.Listener
+	for _, addr := range cfg.Listen {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatalf("Listen: %v", err)
+		}
+		lns = append(lns, ln)
+	}
+	var srvs []*http.Server
+	for i, ln := range lns {
+		ln := ln
+		srv := &http.Server{Addr: cfg.Listen[i]}
+		srvs = append(srvs, srv)
+		spawn(func() {
+			var err error
+			if cfg.TLSCert != "" {
+				err = srv.ServeTLS(ln, cfg.TLSCert, cfg.TLSKey)
+			} else {
+				err = srv.Serve(ln)
 			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Printf("Serve %s: %v", ln.Addr(), err)
+				stop()
+			}
+		})
+	}
+
+	if cfg.SelfTest {
+		spawn(func() { selfTest(lns[0].Addr(), cfg) })
+	}
+
+	<-ctx.Done()
+	log.Printf("Shutting down")
+
+	// The client context is derived from ctx, so the stream reader stops
+	// now and closes ch. Handle whatever is still buffered before exiting.
+	sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	select {
+	case <-done:
+	case <-sctx.Done():
+		log.Printf("Timeout while draining %d buffered events", len(ch))
+	}
+	if rec != nil {
+		if err := rec.Close(); err != nil {
+			log.Printf("record: %v", err)
 		}
-	}()
+	}
 
-	http.Handle("/metrics", promhttp.Handler())
-	http.ListenAndServe(":2113", nil)
+	// Persist the final state before anything else goes away. The client
+	// connections are closed by the deferred Close calls.
+	if statsd != nil {
+		if err := statsd.write(store.Snapshot(), time.Now()); err != nil {
+			log.Printf("statsd: %v", err)
+		}
+	}
+	if influx != nil {
+		if err := influx.write(sctx, store.Snapshot()); err != nil {
+			log.Printf("influx: %v", err)
+		}
+	}
+	if err := store.Flush(sctx); err != nil {
+		log.Printf("Flush: %v", err)
+	}
+	if pusher != nil {
+		if err := pusher.PushContext(sctx); err != nil {
+			pushFailures.Inc()
+			log.Printf("push: %v", err)
+		}
+	}
+
+	var swg sync.WaitGroup
+	for _, srv := range srvs {
+		srv := srv
+		swg.Add(1)
+		spawn(func() {
+			defer swg.Done()
+			if err := srv.Shutdown(sctx); err != nil {
+				log.Printf("Shutdown %s: %v", srv.Addr, err)
+			}
+		})
+	}
+	swg.Wait()
 }
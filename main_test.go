@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestStreamCallOptionsMaxRecv(t *testing.T) {
+	for _, tc := range []struct {
+		env  string
+		want int
+		err  bool
+	}{
+		{env: "", want: defaultGRPCMaxRecv},
+		{env: "33554432", want: 32 << 20},
+		{env: "0", err: true},
+		{env: "-1", err: true},
+	} {
+		t.Run(tc.env, func(t *testing.T) {
+			if tc.env != "" {
+				t.Setenv("LYTGAE_GRPC_MAX_RECV", tc.env)
+			}
+			cfg, err := configFromEnv()
+			if tc.err {
+				if err == nil {
+					t.Fatalf("configFromEnv accepted LYTGAE_GRPC_MAX_RECV=%s", tc.env)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := &Client{maxRecvMsgSize: cfg.GRPCMaxRecv}
+			var got []int
+			for _, opt := range c.streamCallOptions() {
+				if o, ok := opt.(grpc.MaxRecvMsgSizeCallOption); ok {
+					got = append(got, o.MaxRecvMsgSize)
+				}
+			}
+			if len(got) != 1 || got[0] != tc.want {
+				t.Errorf("MaxRecvMsgSize call options = %v, want [%d]", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var gwMutedDesc = prometheus.NewDesc("gateway_muted",
+	"Whether the gateway was muted through the mute endpoint. The alerting rules skip muted gateways.", []string{"gateway"}, nil)
+
+// muteCollector exports the mute state of every gateway in the store, so
+// alerting rules can exclude muted gateways with
+// "unless on(gateway) gateway_muted == 1".
+type muteCollector struct {
+	store Store
+}
+
+func (m muteCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- gwMutedDesc
+}
+
+func (m muteCollector) Collect(ch chan<- prometheus.Metric) {
+	seen := make(map[string]bool)
+	for _, gw := range m.store.Snapshot() {
+		gwid := relabelGateway(gw.id)
+		if seen[gwid] {
+			continue
+		}
+		seen[gwid] = true
+
+		v := 0.0
+		if m.store.Muted(gw.id) {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(gwMutedDesc, prometheus.GaugeValue, v, gwid)
+	}
+}
+
+// muteHandler serves POST /gateways/{id}/mute and /gateways/{id}/unmute.
+// Muting only changes gateway_muted, all metrics of the gateway are still
+// collected. The state is kept in the store, apart from the gateway data,
+// so it survives a restart with the Redis store. Only gateways already in
+// the store can be muted.
+//
+// With access set to configEndpointLocal, only clients on the loopback
+// interface are served.
+func muteHandler(store Store, access string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if access == configEndpointLocal && !isLoopback(r.RemoteAddr) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		id, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/gateways/"), "/")
+		if !ok || id == "" || (action != "mute" && action != "unmute") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if _, ok := store.Get(id); !ok {
+			http.Error(w, "unknown gateway", http.StatusNotFound)
+			return
+		}
+		if store.SetMuted(id, action == "mute") {
+			log.Printf("Gateway %s %sd by %s", id, action, r.RemoteAddr)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gwtest "github.com/feuerrot/lytgae/internal/testutil"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMuteHandler(t *testing.T) {
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	store := newMemoryStore("a", time.Minute, 0, clock)
+	store.Upsert(Gateway{id: "gw-1"})
+	store.Upsert(Gateway{id: "gw-2"})
+	h := muteHandler(store, configEndpointLocal)
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		remote string
+		want   int
+	}{
+		{method: http.MethodPost, path: "/gateways/gw-1/mute", want: http.StatusNoContent},
+		{method: http.MethodPost, path: "/gateways/gw-1/mute", want: http.StatusNoContent},
+		{method: http.MethodPost, path: "/gateways/unknown/mute", want: http.StatusNotFound},
+		{method: http.MethodGet, path: "/gateways/gw-2/mute", want: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, path: "/gateways/gw-2/silence", want: http.StatusNotFound},
+		{method: http.MethodPost, path: "/gateways/gw-2/mute", remote: "192.0.2.1:1234", want: http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		if tc.remote != "" {
+			req.RemoteAddr = tc.remote
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s %s from %s: status = %d, want %d", tc.method, tc.path, req.RemoteAddr, rec.Code, tc.want)
+		}
+	}
+
+	// New connection stats must not undo the mute.
+	updateGateway(store, clock, "gw-1", gwtest.GatewayConnectionStats(gwtest.WithConnectedAt(clock.Now())), clock.Now(), true)
+	defer deleteGatewayMetrics("gw-1")
+
+	want := `
+# HELP gateway_muted Whether the gateway was muted through the mute endpoint. The alerting rules skip muted gateways.
+# TYPE gateway_muted gauge
+gateway_muted{gateway="gw-1"} 1
+gateway_muted{gateway="gw-2"} 0
+`
+	if err := testutil.CollectAndCompare(muteCollector{store: store}, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/gateways/gw-1/unmute", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if store.Muted("gw-1") {
+		t.Errorf("gw-1 is still muted after unmute")
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"time"
+
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+)
+
+// pollConnectionStats fetches the connection stats of all gateways of the
+// client every interval until the client context is done. The stats are
+// published like the ones received as events.
+func (c *Client) pollConnectionStats(interval time.Duration, store Store) error {
+	t := c.clock.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		c.syncConnectionStats(func(gwid string, stats *ttnpb.GatewayConnectionStats) {
+			updateGateway(store, c.clock, gwid, stats, c.clock.Now(), false)
+		})
+
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-t.C():
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeProxy accepts one connection, checks the CONNECT request with check
+// and answers with status. After a successful CONNECT it sends "hello"
+// right behind the response and echoes everything back.
+func fakeProxy(t *testing.T, status int, check func(*http.Request)) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			t.Errorf("proxy: %v", err)
+			return
+		}
+		check(req)
+
+		if status != http.StatusOK {
+			resp := &http.Response{StatusCode: status, ProtoMajor: 1, ProtoMinor: 1}
+			resp.Write(conn)
+			return
+		}
+		// The payload in the same write ends up in the buffer of the
+		// client's response reader.
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\nhello")
+		io.Copy(conn, br)
+	}()
+
+	return "http://" + ln.Addr().String()
+}
+
+func TestConnectDialer(t *testing.T) {
+	const target = "eu1.cloud.thethings.network:8884"
+	proxy := fakeProxy(t, http.StatusOK, func(req *http.Request) {
+		if req.Method != http.MethodConnect {
+			t.Errorf("method = %s, want CONNECT", req.Method)
+		}
+		if req.Host != target {
+			t.Errorf("host = %s, want %s", req.Host, target)
+		}
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))
+		if got := req.Header.Get("Proxy-Authorization"); got != want {
+			t.Errorf("Proxy-Authorization = %q, want %q", got, want)
+		}
+	})
+	u := "http://user:pass@" + proxy[len("http://"):]
+
+	dial, err := connectDialer(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := dial(ctx, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, len("hello"))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("read %q, %v, want the data buffered behind the response", buf, err)
+	}
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	buf = make([]byte, len("ping"))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read %q, %v, want the echo through the tunnel", buf, err)
+	}
+}
+
+func TestConnectDialerRejected(t *testing.T) {
+	proxy := fakeProxy(t, http.StatusProxyAuthRequired, func(*http.Request) {})
+
+	dial, err := connectDialer(proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if conn, err := dial(ctx, "example.com:443"); err == nil {
+		conn.Close()
+		t.Fatal("dial succeeded although the proxy refused the tunnel")
+	}
+}
+
+func TestConnectDialerInvalidURL(t *testing.T) {
+	for _, u := range []string{"socks5://proxy:1080", "http://", "://"} {
+		if _, err := connectDialer(u); err == nil {
+			t.Errorf("connectDialer(%q) accepted an invalid proxy URL", u)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+var pushFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "lytgae_push_failures_total",
+	Help: "Number of failed pushes to the Pushgateway.",
+})
+
+// newPusher returns a pusher for the default registry, grouped by the
+// instance and the server so that several instances don't overwrite each
+// other.
+func newPusher(cfg *Config) *push.Pusher {
+	return push.New(cfg.PushgatewayURL, "lytgae").
+		Gatherer(prometheus.DefaultGatherer).
+		Grouping("instance", cfg.Instance).
+		Grouping("cluster", cfg.Server)
+}
+
+// pushLoop pushes the metrics every interval until ctx is done.
+func pushLoop(ctx context.Context, clock Clock, p *push.Pusher, interval time.Duration) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if err := p.PushContext(ctx); err != nil && ctx.Err() == nil {
+				pushFailures.Inc()
+				log.Printf("push: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var oldestQueuedEventAge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "lytgae_oldest_queued_event_age_seconds",
+	Help: "Age of the oldest event waiting to be processed.",
+})
+
+// eventQueueTimes stores the enqueue timestamps of the events sent to the
+// event channel that have not been received yet, oldest first. As the
+// channel is FIFO, the first entry belongs to the oldest queued event.
+type eventQueueTimes struct {
+	mu    sync.Mutex
+	times []time.Time
+}
+
+var queueTimes = &eventQueueTimes{}
+
+func (q *eventQueueTimes) push(t time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.times = append(q.times, t)
+}
+
+func (q *eventQueueTimes) pop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.times) > 0 {
+		q.times = q.times[1:]
+	}
+}
+
+func (q *eventQueueTimes) oldest() (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.times) == 0 {
+		return time.Time{}, false
+	}
+
+	return q.times[0], true
+}
+
+// sampleQueueAge updates lytgae_oldest_queued_event_age_seconds every
+// interval until ctx is done.
+func sampleQueueAge(ctx context.Context, clock Clock, interval time.Duration) {
+	t := clock.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C():
+			age := 0.0
+			if oldest, ok := queueTimes.oldest(); ok {
+				age = now.Sub(oldest).Seconds()
+			}
+			oldestQueuedEventAge.Set(age)
+		}
+	}
+}
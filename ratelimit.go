@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc/metadata"
+)
+
+var apiRateLimitRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "lytgae_api_rate_limit_remaining",
+	Help: "Remaining API requests in the current rate limit window, by RPC.",
+}, []string{"rpc"})
+
+// rateLimitAvailableKey is the metadata key The Things Stack uses to
+// report the remaining requests of the current rate limit window.
+const rateLimitAvailableKey = "x-rate-limit-available"
+
+// recordRateLimit exports the rate limit headroom reported in the headers or
+// trailers of an RPC. Responses without a valid value are ignored.
+func recordRateLimit(rpc string, mds ...metadata.MD) {
+	for _, md := range mds {
+		for _, v := range md.Get(rateLimitAvailableKey) {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 {
+				continue
+			}
+			apiRateLimitRemaining.WithLabelValues(rpc).Set(f)
+			return
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.thethings.network/lorawan-stack/v3/pkg/events"
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// recordMarshal writes the events in the JSON mapping of the API, so a
+// recording can also be read with the tools used for the Stream endpoint.
+var recordMarshal = protojson.MarshalOptions{UseProtoNames: true}
+
+// recorder appends the streamed events to a file, one JSON object per line.
+// All clients share one recorder.
+type recorder struct {
+	name    string
+	maxSize int64
+
+	mu   sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	size int64
+}
+
+func newRecorder(name string, maxSize int64) (*recorder, error) {
+	r := &recorder{name: name, maxSize: maxSize}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *recorder) open() error {
+	f, err := os.OpenFile(r.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.f = f
+	r.w = bufio.NewWriter(f)
+	r.size = fi.Size()
+	return nil
+}
+
+// write records ev. Errors are logged by the caller, a broken recording
+// must not stop the metrics.
+func (r *recorder) write(ev *ttnpb.Event) error {
+	b, err := recordMarshal.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %v", ev.GetName(), err)
+	}
+	b = append(b, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return fmt.Errorf("%s is closed", r.name)
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(b)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return fmt.Errorf("rotate %s: %v", r.name, err)
+		}
+	}
+
+	n, err := r.w.Write(b)
+	r.size += int64(n)
+	return err
+}
+
+// rotate moves the current file aside with the time of the rotation in its
+// name, events.jsonl becomes events-20060102T150405.jsonl, and starts a new
+// one. Files rotated within the same second get a counter appended,
+// events-20060102T150405-1.jsonl, instead of replacing each other.
+func (r *recorder) rotate() error {
+	if err := r.close(); err != nil {
+		return err
+	}
+
+	ext := ""
+	if i := strings.LastIndexByte(r.name, '.'); i > strings.LastIndexByte(r.name, os.PathSeparator) {
+		ext = r.name[i:]
+	}
+	base := strings.TrimSuffix(r.name, ext) + "-" + time.Now().UTC().Format("20060102T150405")
+	rotated := base + ext
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(rotated); err != nil {
+			break
+		}
+		rotated = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	if err := os.Rename(r.name, rotated); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *recorder) close() error {
+	f := r.f
+	r.f = nil
+	if err := r.w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Close flushes the buffered events and closes the file.
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	return r.close()
+}
+
+// runRecord implements the record command. It streams the events of all
+// API keys into a file without exporting any metrics.
+func runRecord(cfg *Config, args []string) error {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	out := fs.String("o", "events.jsonl", "file the events are written to")
+	maxSize := fs.Int64("max-size", int64(cfg.RecordMaxSize), "rotate the file after this many bytes, 0 disables the rotation")
+	fs.Parse(args)
+
+	if len(cfg.APIKeys) == 0 {
+		return fmt.Errorf("LYTGAE_APIKEY is not set")
+	}
+
+	rec, err := newRecorder(*out, *maxSize)
+	if err != nil {
+		return err
+	}
+	// Also covers the early returns, Close is a no-op the second time.
+	defer rec.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ch := make(chan events.Event, eventBufferSize)
+	var wg sync.WaitGroup
+	for i := range cfg.APIKeys {
+		c, err := NewClient(ctx, cfg, i)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+
+		if c.streamIdentifiers() == nil {
+			log.Printf("key %s: nothing to subscribe to, %s", c.name, c.emptyReason())
+			continue
+		}
+		c.recorder = rec
+
+		wg.Add(1)
+		spawn(func() {
+			defer wg.Done()
+			if err := supervise(ctx, realClock{}, "getEvents", nopReporter{}, func() error {
+				return c.getEvents(ch)
+			}); err != nil {
+				log.Printf("getEvents: %v", err)
+			}
+		})
+	}
+	spawn(func() {
+		wg.Wait()
+		close(ch)
+		stop()
+	})
+
+	// The events are written by the stream readers, nothing is left to do
+	// with them here.
+	for range ch {
+		queueTimes.pop()
+	}
+
+	log.Printf("Closing %s", *out)
+	return rec.Close()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecorderRotateNames(t *testing.T) {
+	dir := t.TempDir()
+	r, err := newRecorder(filepath.Join(dir, "events.jsonl"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// All rotations likely happen within the same second.
+	const rotations = 3
+	for i := 0; i < rotations; i++ {
+		r.mu.Lock()
+		err := r.rotate()
+		r.mu.Unlock()
+		if err != nil {
+			t.Fatalf("rotate: %v", err)
+		}
+	}
+
+	rotated, err := filepath.Glob(filepath.Join(dir, "events-*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != rotations {
+		t.Errorf("got rotated files %v, want %d", rotated, rotations)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "events.jsonl")); err != nil {
+		t.Errorf("no new file after rotating: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "strings"
+
+// regionUnknown is used for frequency plans that are not in regionPrefixes.
+const regionUnknown = "unknown"
+
+// regionPrefixes maps frequency plan ID prefixes to the LoRaWAN region they
+// belong to. The plan IDs follow the naming of the lorawan-frequency-plans
+// repository, e.g. EU_863_870_TTN or US_902_928_FSB_2.
+var regionPrefixes = []struct {
+	prefix string
+	region string
+}{
+	{"EU_863_870", "EU868"},
+	{"EU_433", "EU433"},
+	{"US_902_928", "US915"},
+	{"AU_915_928", "AU915"},
+	{"AS_920_923", "AS923"},
+	{"AS_923_925", "AS923"},
+	{"KR_920_923", "KR920"},
+	{"IN_865_867", "IN865"},
+	{"RU_864_870", "RU864"},
+	{"CN_470_510", "CN470"},
+	{"CN_779_787", "CN779"},
+	{"ISM_2400", "ISM2400"},
+}
+
+// regionFromFrequencyPlan derives the LoRaWAN region from a frequency plan ID.
+func regionFromFrequencyPlan(fp string) string {
+	fp = strings.ToUpper(fp)
+	for _, r := range regionPrefixes {
+		if strings.HasPrefix(fp, r.prefix) {
+			return r.region
+		}
+	}
+
+	return regionUnknown
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// gwRelabel is applied to gateway IDs before they are used as the gateway
+// label, set with LYTGAE_GW_RELABEL. Logs keep the original IDs.
+var gwRelabel struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// setGatewayRelabel configures relabelGateway from a pattern=>replacement
+// spec. The replacement may refer to submatches like regexp.ReplaceAllString.
+// An empty spec disables relabelling.
+func setGatewayRelabel(spec string) error {
+	if spec == "" {
+		gwRelabel.re = nil
+		return nil
+	}
+
+	pattern, replacement, ok := strings.Cut(spec, "=>")
+	if !ok {
+		return fmt.Errorf("expected pattern=>replacement")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+
+	gwRelabel.re = re
+	gwRelabel.replacement = replacement
+	return nil
+}
+
+// relabelGateway returns the gateway label value for a gateway ID.
+func relabelGateway(id string) string {
+	if gwRelabel.re == nil {
+		return id
+	}
+
+	return gwRelabel.re.ReplaceAllString(id, gwRelabel.replacement)
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestRelabelGateway(t *testing.T) {
+	defer setGatewayRelabel("")
+
+	for _, tc := range []struct {
+		spec string
+		id   string
+		want string
+	}{
+		{spec: "", id: "acme-eu-gw-0001", want: "acme-eu-gw-0001"},
+		{spec: "^acme-eu-=>", id: "acme-eu-gw-0001", want: "gw-0001"},
+		{spec: "^acme-eu-=>", id: "other-gw", want: "other-gw"},
+		{spec: "^acme-(..)-gw-0*(\\d+)$=>$1-$2", id: "acme-eu-gw-0042", want: "eu-42"},
+		{spec: "-=>_", id: "a-b-c", want: "a_b_c"},
+	} {
+		if err := setGatewayRelabel(tc.spec); err != nil {
+			t.Fatalf("setGatewayRelabel(%q): %v", tc.spec, err)
+		}
+		if got := relabelGateway(tc.id); got != tc.want {
+			t.Errorf("%q: relabelGateway(%q) = %q, want %q", tc.spec, tc.id, got, tc.want)
+		}
+	}
+}
+
+func TestSetGatewayRelabelInvalid(t *testing.T) {
+	defer setGatewayRelabel("")
+
+	for _, spec := range []string{"no-arrow", "(=>x"} {
+		if err := setGatewayRelabel(spec); err == nil {
+			t.Errorf("setGatewayRelabel(%q) accepted an invalid spec", spec)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"go.thethings.network/lorawan-stack/v3/pkg/events"
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// replay feeds the events recorded in name into ec instead of a live
+// stream. The event times are moved so the first event happens now, and
+// the time between two events is divided by speed. At speed zero the events
+// are sent as fast as the consumer takes them and get the current time.
+//
+// Only the event time is moved, timestamps in the event data like the
+// last uplink of the connection stats keep their recorded value.
+func replay(ctx context.Context, name string, speed float64, ec chan<- events.Event) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var (
+		r     = bufio.NewReader(f)
+		start = time.Now()
+		first time.Time
+		n     int
+	)
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if b = bytes.TrimSpace(b); len(b) > 0 {
+			pb := &ttnpb.Event{}
+			if err := protojson.Unmarshal(b, pb); err != nil {
+				return fmt.Errorf("%s:%d: %v", name, line, err)
+			}
+
+			at := time.Now()
+			if speed > 0 {
+				t := pb.GetTime().AsTime()
+				if first.IsZero() {
+					first = t
+				}
+				at = start.Add(time.Duration(float64(t.Sub(first)) / speed))
+				select {
+				case <-time.After(time.Until(at)):
+				case <-ctx.Done():
+					return nil
+				}
+			}
+			pb.Time = timestamppb.New(at)
+
+			ev, err := events.FromProto(pb)
+			if err != nil {
+				return fmt.Errorf("%s:%d: FromProto: %v", name, line, err)
+			}
+			queueTimes.push(time.Now())
+			select {
+			case ec <- ev:
+			case <-ctx.Done():
+				queueTimes.pop()
+				return nil
+			}
+			n++
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	log.Printf("Replayed %d events from %s", n, name)
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	rdebug "runtime/debug"
+	"strings"
+	"time"
+)
+
+// superviseRestartDelay is the time supervise waits before restarting a
+// function that panicked.
+const superviseRestartDelay = time.Second
+
+// Reporter sends unexpected errors to an error tracking backend.
+type Reporter interface {
+	Report(err error)
+}
+
+type nopReporter struct{}
+
+func (nopReporter) Report(error) {}
+
+// newReporter returns a Sentry reporter for dsn, or a reporter that does
+// nothing if dsn is empty.
+func newReporter(dsn string, clock Clock) (Reporter, error) {
+	if dsn == "" {
+		return nopReporter{}, nil
+	}
+
+	return newSentryReporter(dsn, clock)
+}
+
+// sentryReporter sends errors to the Sentry envelope endpoint.
+type sentryReporter struct {
+	dsn      string
+	endpoint string
+	auth     string
+	client   *http.Client
+	breaker  *breaker
+	clock    Clock
+}
+
+func newSentryReporter(dsn string, clock Clock) (*sentryReporter, error) {
+	endpoint, key, err := sentryEndpoint(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sentryReporter{
+		dsn:      dsn,
+		endpoint: endpoint,
+		auth:     fmt.Sprintf("Sentry sentry_version=7, sentry_client=lytgae/1.0, sentry_key=%s", key),
+		client:   &http.Client{Timeout: 5 * time.Second},
+		breaker:  newBreaker("sentry", 5, time.Minute, clock),
+		clock:    clock,
+	}, nil
+}
+
+// sentryEndpoint returns the envelope endpoint and the public key of a
+// DSN. The project ID is the last path segment, anything before it is a
+// prefix for Sentry instances not served from the root:
+// https://key@host/prefix/42 posts to https://host/prefix/api/42/envelope/.
+func sentryEndpoint(dsn string) (string, string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", fmt.Errorf("parse DSN: %v", err)
+	}
+	key := u.User.Username()
+	prefix, project := path.Split(strings.TrimSuffix(u.Path, "/"))
+	if key == "" || u.Host == "" || project == "" {
+		return "", "", fmt.Errorf("DSN must look like https://<key>@<host>[/<prefix>]/<project>")
+	}
+
+	endpoint := url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   path.Join(prefix, "api", project, "envelope") + "/",
+	}
+	return endpoint.String(), key, nil
+}
+
+func (s *sentryReporter) Report(err error) {
+	serr := s.breaker.call(func() error {
+		return s.send(err)
+	})
+	if serr != nil && serr != errBreakerOpen {
+		log.Printf("sentry: %v", serr)
+	}
+}
+
+func (s *sentryReporter) send(report error) error {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	now := s.clock.Now().UTC().Format(time.RFC3339)
+	event, err := json.Marshal(map[string]any{
+		"event_id":  hex.EncodeToString(id),
+		"timestamp": now,
+		"level":     "error",
+		"platform":  "go",
+		"logger":    "lytgae",
+		"message":   report.Error(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// An envelope is a header line followed by items, each an item header
+	// line and the payload.
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.Encode(map[string]any{"event_id": hex.EncodeToString(id), "sent_at": now, "dsn": s.dsn})
+	enc.Encode(map[string]any{"type": "event", "length": len(event)})
+	body.Write(event)
+	body.WriteByte('\n')
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// supervise runs f and restarts it after a panic, which is reported to r
+// instead of taking down the process. Errors returned by f are reported as
+// well and then returned. If ctx is done while waiting to restart, the
+// panic is returned as error.
+func supervise(ctx context.Context, clock Clock, name string, r Reporter, f func() error) error {
+	for {
+		err, panicked := runRecover(f)
+		if !panicked {
+			if err != nil {
+				r.Report(fmt.Errorf("%s: %v", name, err))
+			}
+			return err
+		}
+
+		log.Printf("%s: %v, restarting", name, err)
+		r.Report(fmt.Errorf("%s: %v", name, err))
+		select {
+		case <-clock.After(superviseRestartDelay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func runRecover(f func() error) (err error, panicked bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v\n%s", v, rdebug.Stack())
+			panicked = true
+		}
+	}()
+
+	return f(), false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSentryEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		dsn      string
+		endpoint string
+		key      string
+		err      bool
+	}{
+		{dsn: "https://abc@o1.ingest.sentry.io/42", endpoint: "https://o1.ingest.sentry.io/api/42/envelope/", key: "abc"},
+		{dsn: "https://abc@sentry.example.com/sentry/42", endpoint: "https://sentry.example.com/sentry/api/42/envelope/", key: "abc"},
+		{dsn: "http://abc@localhost:9000/a/b/42/", endpoint: "http://localhost:9000/a/b/api/42/envelope/", key: "abc"},
+		{dsn: "https://sentry.example.com/42", err: true},
+		{dsn: "https://abc@sentry.example.com/", err: true},
+	} {
+		endpoint, key, err := sentryEndpoint(tc.dsn)
+		if tc.err {
+			if err == nil {
+				t.Errorf("sentryEndpoint(%q) = %q, want error", tc.dsn, endpoint)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("sentryEndpoint(%q): %v", tc.dsn, err)
+			continue
+		}
+		if endpoint != tc.endpoint || key != tc.key {
+			t.Errorf("sentryEndpoint(%q) = %q, %q, want %q, %q", tc.dsn, endpoint, key, tc.endpoint, tc.key)
+		}
+	}
+}
+
+type testReporter struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (r *testReporter) Report(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+func TestSuperviseStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &testReporter{}
+
+	done := make(chan error)
+	go func() {
+		done <- supervise(ctx, realClock{}, "test", r, func() error {
+			cancel()
+			panic("boom")
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("supervise returned nil, want the panic")
+		}
+	case <-time.After(superviseRestartDelay / 2):
+		t.Fatalf("supervise waited for the restart delay after cancel")
+	}
+	if len(r.errs) != 1 {
+		t.Errorf("got %d reports, want 1", len(r.errs))
+	}
+}
+
+func TestSuperviseRestarts(t *testing.T) {
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	r := &testReporter{}
+
+	calls := 0
+	done := make(chan error)
+	go func() {
+		done <- supervise(context.Background(), clock, "test", r, func() error {
+			calls++
+			if calls == 1 {
+				panic("boom")
+			}
+			return nil
+		})
+	}()
+
+	// Advance until supervise waits for the restart delay and returns.
+	deadline := time.After(5 * time.Second)
+	for {
+		clock.Advance(superviseRestartDelay)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("supervise = %v, want nil after the restart", err)
+			}
+			if len(r.errs) != 1 {
+				t.Errorf("got %d reports, want 1", len(r.errs))
+			}
+			return
+		case <-deadline:
+			t.Fatal("supervise did not restart")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestSuperviseReturnsError(t *testing.T) {
+	want := errors.New("failed")
+	r := &testReporter{}
+
+	if err := supervise(context.Background(), realClock{}, "test", r, func() error { return want }); err != want {
+		t.Errorf("supervise = %v, want %v", err, want)
+	}
+	if len(r.errs) != 1 {
+		t.Errorf("got %d reports, want 1", len(r.errs))
+	}
+}
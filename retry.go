@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+
+	// The budget follows the gRPC retry throttling: every failure costs a
+	// token, every success earns a fraction of one back, and retries stop
+	// while less than half of the tokens are left.
+	retryBudgetTokens = 10
+	retryBudgetRatio  = 0.1
+)
+
+var rpcRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "lytgae_rpc_retries_total",
+	Help: "Number of retried RPCs, by method.",
+}, []string{"method"})
+
+// retrier retries failed unary calls, and server streams until their first
+// message, of a connection with exponential backoff, or the delay the
+// server asked for in RetryInfo. All RPCs share one retry budget, so a
+// failing server is not hammered by retries of every call.
+type retrier struct {
+	maxAttempts int
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+func newRetrier(maxAttempts int) *retrier {
+	return &retrier{
+		maxAttempts: maxAttempts,
+		tokens:      retryBudgetTokens,
+	}
+}
+
+func (r *retrier) unary(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return r.do(ctx, method, func() error {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+}
+
+func (r *retrier) stream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	open := func() (grpc.ClientStream, error) {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+
+	var cs grpc.ClientStream
+	err := r.do(ctx, method, func() error {
+		var err error
+		cs, err = open()
+		return err
+	})
+	if err != nil || !desc.ServerStreams || desc.ClientStreams {
+		return cs, err
+	}
+
+	return &retryStream{ClientStream: cs, r: r, ctx: ctx, method: method, open: open}, nil
+}
+
+// retryStream retries a server stream until its first message. Creating
+// a stream hardly ever fails, an unavailable server is only reported by
+// the first RecvMsg. The request is sent again on the new stream, once a
+// message was received errors are returned as they are.
+type retryStream struct {
+	grpc.ClientStream
+	r      *retrier
+	ctx    context.Context
+	method string
+	open   func() (grpc.ClientStream, error)
+
+	req      any
+	closed   bool
+	received bool
+}
+
+func (s *retryStream) SendMsg(m any) error {
+	s.req = m
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *retryStream) CloseSend() error {
+	s.closed = true
+	return s.ClientStream.CloseSend()
+}
+
+func (s *retryStream) RecvMsg(m any) error {
+	if s.received {
+		return s.ClientStream.RecvMsg(m)
+	}
+
+	retry := false
+	err := s.r.do(s.ctx, s.method, func() error {
+		if retry {
+			if err := s.reopen(); err != nil {
+				return err
+			}
+		}
+		retry = true
+		return s.ClientStream.RecvMsg(m)
+	})
+	s.received = err == nil
+
+	return err
+}
+
+func (s *retryStream) reopen() error {
+	cs, err := s.open()
+	if err != nil {
+		return err
+	}
+	s.ClientStream = cs
+
+	// An error of the stream is returned by RecvMsg, SendMsg only
+	// reports io.EOF then.
+	if s.req != nil {
+		if err := cs.SendMsg(s.req); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	if s.closed {
+		return cs.CloseSend()
+	}
+
+	return nil
+}
+
+func (r *retrier) do(ctx context.Context, method string, call func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := call()
+		r.record(err)
+		if err == nil || attempt >= r.maxAttempts || !retryable(err) || !r.allow() {
+			return err
+		}
+
+		delay := retryDelay(err, attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		debugf("%s: %v, retrying in %s", method, err, delay)
+		rpcRetries.WithLabelValues(method).Inc()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (r *retrier) record(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err == nil {
+		r.tokens = min(r.tokens+retryBudgetRatio, retryBudgetTokens)
+	} else if retryable(err) {
+		r.tokens = max(r.tokens-1, 0)
+	}
+}
+
+func (r *retrier) allow() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.tokens > retryBudgetTokens/2
+}
+
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted:
+		return true
+	}
+
+	return false
+}
+
+// isGoAway reports whether err ended a stream because the server sent
+// GOAWAY, like during a rolling deploy. gRPC already connects to another
+// backend then, so the stream can be reopened right away. gRPC only tells
+// this apart by the message of the Unavailable status.
+func isGoAway(err error) bool {
+	s, ok := status.FromError(err)
+	if !ok || s.Code() != codes.Unavailable {
+		return false
+	}
+	msg := strings.ToLower(s.Message())
+
+	return strings.Contains(msg, "goaway") || strings.Contains(msg, "draining")
+}
+
+// retryDelay returns the delay from the RetryInfo detail of err, if the
+// server sent one, or the exponential backoff for attempt. The delay of
+// the server is limited to retryMaxDelay as well.
+func retryDelay(err error, attempt int) time.Duration {
+	if s, ok := status.FromError(err); ok {
+		for _, d := range s.Details() {
+			if ri, ok := d.(*errdetails.RetryInfo); ok && ri.GetRetryDelay() != nil {
+				return min(max(ri.GetRetryDelay().AsDuration(), 0), retryMaxDelay)
+			}
+		}
+	}
+
+	return min(retryBaseDelay<<(attempt-1), retryMaxDelay)
+}
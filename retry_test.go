@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func retryInfoError(t *testing.T, d time.Duration) error {
+	t.Helper()
+
+	s, err := status.New(codes.Unavailable, "unavailable").WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(d),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Err()
+}
+
+func TestRetryDelay(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+
+	for _, tc := range []struct {
+		name    string
+		err     error
+		attempt int
+		want    time.Duration
+	}{
+		{name: "first attempt", err: unavailable, attempt: 1, want: retryBaseDelay},
+		{name: "backoff", err: unavailable, attempt: 3, want: 4 * retryBaseDelay},
+		{name: "max backoff", err: unavailable, attempt: 10, want: retryMaxDelay},
+		{name: "retry info", err: retryInfoError(t, 2*time.Second), attempt: 5, want: 2 * time.Second},
+		{name: "retry info above max", err: retryInfoError(t, time.Hour), attempt: 1, want: retryMaxDelay},
+		{name: "negative retry info", err: retryInfoError(t, -time.Second), attempt: 1, want: 0},
+	} {
+		if got := retryDelay(tc.err, tc.attempt); got != tc.want {
+			t.Errorf("%s: retryDelay = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRetrierRespectsDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	r := newRetrier(3)
+	calls := 0
+	start := time.Now()
+	err := r.do(ctx, "test", func() error {
+		calls++
+		return retryInfoError(t, time.Hour)
+	})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("do = %v, want the Unavailable error", err)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want no retry past the deadline", calls)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("do waited %s although the delay exceeds the deadline", d)
+	}
+}
+
+type fakeStream struct {
+	grpc.ClientStream
+	recvErr error
+	sent    []any
+	closed  bool
+}
+
+func (s *fakeStream) SendMsg(m any) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func (s *fakeStream) CloseSend() error {
+	s.closed = true
+	return nil
+}
+
+func (s *fakeStream) RecvMsg(any) error { return s.recvErr }
+
+func TestRetrierStreamRetriesFirstRecv(t *testing.T) {
+	var streams []*fakeStream
+	streamer := func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+		s := &fakeStream{}
+		if len(streams) == 0 {
+			s.recvErr = retryInfoError(t, 0)
+		}
+		streams = append(streams, s)
+		return s, nil
+	}
+
+	r := newRetrier(3)
+	desc := &grpc.StreamDesc{ServerStreams: true}
+	cs, err := r.stream(context.Background(), desc, nil, "test", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.SendMsg("req")
+	cs.CloseSend()
+
+	if err := cs.RecvMsg(nil); err != nil {
+		t.Fatalf("RecvMsg = %v, want the message of the second stream", err)
+	}
+	if len(streams) != 2 {
+		t.Fatalf("got %d streams, want 2", len(streams))
+	}
+	if s := streams[1]; len(s.sent) != 1 || s.sent[0] != "req" || !s.closed {
+		t.Errorf("the second stream got %v, closed %t, want the request and CloseSend", s.sent, s.closed)
+	}
+
+	// Once a message was received the errors are returned as they are.
+	streams[1].recvErr = retryInfoError(t, 0)
+	if err := cs.RecvMsg(nil); status.Code(err) != codes.Unavailable {
+		t.Errorf("RecvMsg = %v, want the Unavailable error", err)
+	}
+	if len(streams) != 2 {
+		t.Errorf("got %d streams, want no retry after the first message", len(streams))
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	thresholdUplinkAge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "lytgae_threshold_uplink_age_seconds",
+		Help: "Time without uplinks after which GatewayNoUplink fires, from LYTGAE_RULES_NO_UPLINK.",
+	})
+	thresholdDutyCycle = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "lytgae_threshold_duty_cycle_ratio",
+		Help: "Share of the duty cycle limit above which GatewayDutyCycleHigh fires, from LYTGAE_RULES_DUTY_CYCLE.",
+	})
+)
+
+// publishThresholds exports the thresholds of the alerting rules, so
+// dashboards can draw them without repeating the configuration.
+func publishThresholds(cfg *Config) {
+	thresholdUplinkAge.Set(cfg.RulesNoUplink.Seconds())
+	thresholdDutyCycle.Set(cfg.RulesDutyCycle)
+}
+
+// unlessMuted removes the gateways muted through the mute endpoint from the
+// result of a gateway alert.
+const unlessMuted = " unless on(gateway) %[1]sgateway_muted == 1"
+
+// rules returns recommended Prometheus alerting rules for the metrics of
+// lytgae, with the thresholds from cfg. The metric names carry the prefix
+// of LYTGAE_NAMESPACE, like the scrape config renames them. In the
+// expressions %[1]s stands for that prefix.
+func rules(cfg *Config) string {
+	var ns string
+	if cfg.Namespace != "" {
+		ns = cfg.Namespace + "_"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "groups:\n")
+	fmt.Fprintf(&b, "  - name: lytgae\n")
+	fmt.Fprintf(&b, "    rules:\n")
+
+	rule(&b, "LytgaeNoStreams", fmt.Sprintf("%[1]slytgae_active_streams == 0", ns), 5*time.Minute, "critical",
+		"lytgae {{ $labels.instance }} has no event stream")
+	rule(&b, "GatewayDown", fmt.Sprintf("%[1]sgateway_connected == 0"+unlessMuted, ns), 5*time.Minute, "critical",
+		"Gateway {{ $labels.gateway }} is disconnected")
+	rule(&b, "GatewayNoUplink",
+		fmt.Sprintf(`time() - %[1]sgateway_timestamp_seconds{type="uplink"} > %[2]d`+unlessMuted, ns, int64(cfg.RulesNoUplink.Seconds())),
+		0, "warning",
+		fmt.Sprintf("Gateway {{ $labels.gateway }} received no uplink for more than %s", cfg.RulesNoUplink))
+	rule(&b, "GatewayDutyCycleHigh",
+		fmt.Sprintf("%[1]sgateway_subband_downlink_utilization / %[1]sgateway_subband_downlink_utilization_limit > %[2]g"+unlessMuted, ns, cfg.RulesDutyCycle),
+		15*time.Minute, "warning",
+		fmt.Sprintf("Gateway {{ $labels.gateway }} uses more than %g%% of the duty cycle limit between {{ $labels.min_frequency }} and {{ $labels.max_frequency }} Hz", cfg.RulesDutyCycle*100))
+
+	return b.String()
+}
+
+func rule(b *strings.Builder, name, expr string, d time.Duration, severity, summary string) {
+	fmt.Fprintf(b, "      - alert: %s\n", name)
+	fmt.Fprintf(b, "        expr: %q\n", expr)
+	if d > 0 {
+		fmt.Fprintf(b, "        for: %dm\n", int64(d.Minutes()))
+	}
+	fmt.Fprintf(b, "        labels:\n")
+	fmt.Fprintf(b, "          severity: %s\n", severity)
+	fmt.Fprintf(b, "        annotations:\n")
+	fmt.Fprintf(b, "          summary: %q\n", summary)
+}
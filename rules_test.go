@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type testRules struct {
+	Groups []struct {
+		Name  string `yaml:"name"`
+		Rules []struct {
+			Alert       string            `yaml:"alert"`
+			Expr        string            `yaml:"expr"`
+			For         string            `yaml:"for"`
+			Labels      map[string]string `yaml:"labels"`
+			Annotations map[string]string `yaml:"annotations"`
+		} `yaml:"rules"`
+	} `yaml:"groups"`
+}
+
+func TestRules(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		cfg    Config
+		prefix string
+	}{
+		{name: "default", cfg: Config{RulesNoUplink: time.Hour, RulesDutyCycle: 0.8}},
+		{name: "namespace", cfg: Config{RulesNoUplink: time.Hour, RulesDutyCycle: 0.8, Namespace: "ttn"}, prefix: "ttn_"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := rules(&tc.cfg)
+
+			var r testRules
+			if err := yaml.Unmarshal([]byte(out), &r); err != nil {
+				t.Fatalf("output is not valid YAML: %v\n%s", err, out)
+			}
+			if len(r.Groups) != 1 {
+				t.Fatalf("got %d groups, want 1", len(r.Groups))
+			}
+
+			want := map[string]struct {
+				metric string
+				expr   string
+				for_   string
+			}{
+				"LytgaeNoStreams":      {metric: "lytgae_active_streams", for_: "5m"},
+				"GatewayDown":          {metric: "gateway_connected", for_: "5m"},
+				"GatewayNoUplink":      {metric: "gateway_timestamp_seconds", expr: "> 3600"},
+				"GatewayDutyCycleHigh": {metric: "gateway_subband_downlink_utilization_limit", expr: "> 0.8", for_: "15m"},
+			}
+			for _, rule := range r.Groups[0].Rules {
+				w, ok := want[rule.Alert]
+				if !ok {
+					t.Errorf("unexpected alert %s", rule.Alert)
+					continue
+				}
+				delete(want, rule.Alert)
+
+				if !strings.Contains(rule.Expr, tc.prefix+w.metric) || !strings.Contains(rule.Expr, w.expr) {
+					t.Errorf("%s: expr = %q, want %s%s and %q", rule.Alert, rule.Expr, tc.prefix, w.metric, w.expr)
+				}
+				if rule.Alert != "LytgaeNoStreams" && !strings.Contains(rule.Expr, tc.prefix+"gateway_muted") {
+					t.Errorf("%s: expr = %q, does not skip muted gateways", rule.Alert, rule.Expr)
+				}
+				if tc.prefix != "" && strings.Contains(rule.Expr, " gateway_") {
+					t.Errorf("%s: expr = %q, has metrics without the namespace", rule.Alert, rule.Expr)
+				}
+				if rule.For != w.for_ {
+					t.Errorf("%s: for = %q, want %q", rule.Alert, rule.For, w.for_)
+				}
+				if rule.Labels["severity"] == "" || rule.Annotations["summary"] == "" {
+					t.Errorf("%s: missing severity or summary", rule.Alert)
+				}
+			}
+			for alert := range want {
+				t.Errorf("alert %s is missing", alert)
+			}
+		})
+	}
+}
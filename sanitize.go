@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxLabelLength truncates label values taken from free-form metadata, set
+// with LYTGAE_MAX_LABEL_LENGTH.
+var maxLabelLength = 128
+
+var labelValuesSanitized = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "lytgae_label_values_sanitized_total",
+	Help: "Number of label values from gateway metadata that had to be changed, by label.",
+}, []string{"label"})
+
+// sanitizeLabel returns v as a value for label. Invalid UTF-8 and control
+// characters are replaced with underscores and values longer than
+// maxLabelLength characters are truncated. It is meant for values from
+// free-form metadata like gateway attributes and status versions, not for
+// gateway IDs, which the server already restricts.
+func sanitizeLabel(label, v string) string {
+	s := strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, v)
+	if utf8.RuneCountInString(s) > maxLabelLength {
+		s = string([]rune(s)[:maxLabelLength])
+	}
+
+	if s != v {
+		labelValuesSanitized.WithLabelValues(label).Inc()
+	}
+
+	return s
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// scrapeConfig returns a prometheus.yml scrape_configs snippet for the metrics
+// endpoint described by cfg. With several listen addresses the first one
+// is used.
+func scrapeConfig(cfg *Config) string {
+	host, port, err := net.SplitHostPort(cfg.Listen[0])
+	if err != nil {
+		host, port = "", strings.TrimPrefix(cfg.Listen[0], ":")
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "scrape_configs:\n")
+	fmt.Fprintf(&b, "  - job_name: lytgae\n")
+	if cfg.TLSCert != "" {
+		fmt.Fprintf(&b, "    scheme: https\n")
+	}
+	if cfg.MetricsToken != "" {
+		fmt.Fprintf(&b, "    authorization:\n")
+		fmt.Fprintf(&b, "      type: Bearer\n")
+		fmt.Fprintf(&b, "      # file containing the value of LYTGAE_METRICS_TOKEN\n")
+		fmt.Fprintf(&b, "      credentials_file: /etc/prometheus/lytgae.token\n")
+	}
+	fmt.Fprintf(&b, "    static_configs:\n")
+	fmt.Fprintf(&b, "      - targets: [%q]\n", net.JoinHostPort(host, port))
+	if cfg.Namespace != "" {
+		fmt.Fprintf(&b, "    metric_relabel_configs:\n")
+		fmt.Fprintf(&b, "      # prefix all metrics with LYTGAE_NAMESPACE\n")
+		fmt.Fprintf(&b, "      - source_labels: [__name__]\n")
+		fmt.Fprintf(&b, "        regex: \"(.+)\"\n")
+		fmt.Fprintf(&b, "        target_label: __name__\n")
+		fmt.Fprintf(&b, "        replacement: %q\n", cfg.Namespace+"_$1")
+	}
+
+	return b.String()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+type testScrapeConfigs struct {
+	ScrapeConfigs []struct {
+		JobName       string `yaml:"job_name"`
+		Scheme        string `yaml:"scheme"`
+		Authorization struct {
+			Type            string `yaml:"type"`
+			CredentialsFile string `yaml:"credentials_file"`
+		} `yaml:"authorization"`
+		StaticConfigs []struct {
+			Targets []string `yaml:"targets"`
+		} `yaml:"static_configs"`
+		MetricRelabelConfigs []struct {
+			SourceLabels []string `yaml:"source_labels"`
+			Regex        string   `yaml:"regex"`
+			TargetLabel  string   `yaml:"target_label"`
+			Replacement  string   `yaml:"replacement"`
+		} `yaml:"metric_relabel_configs"`
+	} `yaml:"scrape_configs"`
+}
+
+func TestScrapeConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		cfg       Config
+		target    string
+		scheme    string
+		token     bool
+		namespace string
+	}{
+		{
+			name:   "default",
+			cfg:    Config{Listen: []string{":2113"}},
+			target: "localhost:2113",
+		},
+		{
+			name:   "host and tls",
+			cfg:    Config{Listen: []string{"10.0.0.1:9000", ":2113"}, TLSCert: "cert.pem", MetricsToken: "secret"},
+			target: "10.0.0.1:9000",
+			scheme: "https",
+			token:  true,
+		},
+		{
+			name:      "namespace",
+			cfg:       Config{Listen: []string{"[::]:2113"}, Namespace: "ttn"},
+			target:    "localhost:2113",
+			namespace: "ttn",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := scrapeConfig(&tc.cfg)
+
+			var sc testScrapeConfigs
+			if err := yaml.Unmarshal([]byte(out), &sc); err != nil {
+				t.Fatalf("output is not valid YAML: %v\n%s", err, out)
+			}
+			if len(sc.ScrapeConfigs) != 1 {
+				t.Fatalf("got %d scrape configs, want 1", len(sc.ScrapeConfigs))
+			}
+			job := sc.ScrapeConfigs[0]
+
+			if job.JobName != "lytgae" {
+				t.Errorf("job_name = %q, want lytgae", job.JobName)
+			}
+			if len(job.StaticConfigs) != 1 || len(job.StaticConfigs[0].Targets) != 1 || job.StaticConfigs[0].Targets[0] != tc.target {
+				t.Errorf("static_configs = %+v, want target %s", job.StaticConfigs, tc.target)
+			}
+			if job.Scheme != tc.scheme {
+				t.Errorf("scheme = %q, want %q", job.Scheme, tc.scheme)
+			}
+			if got := job.Authorization.Type == "Bearer"; got != tc.token {
+				t.Errorf("bearer authorization = %t, want %t", got, tc.token)
+			}
+
+			if tc.namespace == "" {
+				if len(job.MetricRelabelConfigs) != 0 {
+					t.Errorf("metric_relabel_configs = %+v, want none", job.MetricRelabelConfigs)
+				}
+				return
+			}
+			if len(job.MetricRelabelConfigs) != 1 {
+				t.Fatalf("got %d metric_relabel_configs, want 1", len(job.MetricRelabelConfigs))
+			}
+			rc := job.MetricRelabelConfigs[0]
+			if rc.TargetLabel != "__name__" || rc.Replacement != tc.namespace+"_$1" {
+				t.Errorf("relabel = %+v, want __name__ prefixed with %s_", rc, tc.namespace)
+			}
+		})
+	}
+}
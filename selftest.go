@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// selfTest fetches /metrics from the server listening on addr and logs the
+// result. Failures are only logged, as the listen address may not be
+// reachable from the process itself in some network setups.
+func selfTest(addr net.Addr, cfg *Config) {
+	if err := fetchMetrics(addr, cfg); err != nil {
+		log.Printf("Self-test failed: %v", err)
+		return
+	}
+	log.Printf("Self-test: metrics endpoint on %s is reachable", addr)
+}
+
+func fetchMetrics(addr net.Addr, cfg *Config) error {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+
+	scheme := "http"
+	if cfg.TLSCert != "" {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s/metrics", scheme, net.JoinHostPort(host, port))
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if cfg.MetricsToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.MetricsToken)
+	}
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			// The certificate is usually not issued for localhost.
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultStatsdName = "lytgae.gateway.{gateway}.{metric}"
+	// statsdMaxPacket keeps the datagrams below the common Ethernet MTU.
+	statsdMaxPacket = 1432
+)
+
+var statsdFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "lytgae_statsd_send_failures_total",
+	Help: "Number of StatsD packets that could not be sent.",
+})
+
+// statsdWriter sends the gateways of the store to a StatsD server. The
+// values are the ones exported to Prometheus: gauges for the connection
+// state, the ages and the averages, and counters for the uplinks,
+// downlinks and TX acknowledgments. They are computed from the store, not
+// gathered from the Prometheus registry.
+type statsdWriter struct {
+	name string
+	conn net.Conn
+
+	// mu guards last, the counts sent for every gateway. StatsD counters
+	// are increments, so only the difference to the last write is sent.
+	mu   sync.Mutex
+	last map[string][3]uint64
+}
+
+func newStatsdWriter(cfg *Config) (*statsdWriter, error) {
+	conn, err := net.Dial("udp", cfg.StatsdAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statsdWriter{
+		name: cfg.StatsdName,
+		conn: conn,
+		last: make(map[string][3]uint64),
+	}, nil
+}
+
+func (w *statsdWriter) Close() error {
+	return w.conn.Close()
+}
+
+// statsdLoop sends the store to StatsD every interval until ctx is done.
+func statsdLoop(ctx context.Context, w *statsdWriter, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := w.write(store.Snapshot(), now); err != nil {
+				log.Printf("statsd: %v", err)
+			}
+		}
+	}
+}
+
+// write sends the metrics of gws, in as few packets as fit. A failed
+// packet does not stop the others, the first error is returned.
+func (w *statsdWriter) write(gws []Gateway, now time.Time) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var (
+		rtn error
+		buf bytes.Buffer
+	)
+	send := func() {
+		if buf.Len() == 0 {
+			return
+		}
+		if _, err := w.conn.Write(buf.Bytes()); err != nil {
+			statsdFailures.Inc()
+			if rtn == nil {
+				rtn = err
+			}
+		}
+		buf.Reset()
+	}
+	add := func(line string) {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > statsdMaxPacket {
+			send()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+
+	seen := make(map[string]bool)
+	for _, gw := range gws {
+		gwid := relabelGateway(gw.id)
+		if seen[gwid] {
+			continue
+		}
+		seen[gwid] = true
+
+		for _, line := range w.lines(gwid, gw, now) {
+			add(line)
+		}
+	}
+	send()
+
+	for gwid := range w.last {
+		if !seen[gwid] {
+			delete(w.last, gwid)
+		}
+	}
+
+	return rtn
+}
+
+// lines returns the StatsD lines of a gateway and remembers its counts.
+func (w *statsdWriter) lines(gwid string, gw Gateway, now time.Time) []string {
+	var rtn []string
+	gauge := func(metric string, v float64) {
+		rtn = append(rtn, statsdName(w.name, gwid, metric)+":"+strconv.FormatFloat(v, 'f', -1, 64)+"|g")
+	}
+
+	connected := 0.0
+	if gw.connected() {
+		connected = 1
+	}
+	gauge("connected", connected)
+	if gw.statsInterval != 0 {
+		gauge("stats_interval_seconds", gw.statsInterval.Seconds())
+	}
+	if gw.snrSamples != 0 {
+		gauge("snr_avg", gw.snrAvg)
+	}
+
+	counts := [3]uint64{gw.uplinkCount, gw.downlinkCount, gw.txAckCount}
+	prev, known := w.last[gwid]
+	w.last[gwid] = counts
+	for i, f := range []struct {
+		name string
+		t    time.Time
+	}{
+		{"uplink", gw.uplinkTime},
+		{"downlink", gw.downlinkTime},
+		{"txack", gw.txAckTime},
+	} {
+		if counts[i] != 0 {
+			gauge(f.name+"_age_seconds", now.Sub(f.t).Seconds())
+		}
+		// The first write only records the counts, the counts start over
+		// when the gateway reconnects.
+		if !known {
+			continue
+		}
+		d := counts[i]
+		if counts[i] >= prev[i] {
+			d -= prev[i]
+		}
+		if d != 0 {
+			rtn = append(rtn, fmt.Sprintf("%s:%d|c", statsdName(w.name, gwid, f.name+"s"), d))
+		}
+	}
+
+	return rtn
+}
+
+var statsdNameEscaper = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", " ", "_", "\n", "_")
+
+// statsdName fills in the name template, like the default
+// "lytgae.gateway.{gateway}.{metric}". The gateway ID is escaped so it
+// stays a single part of the name.
+func statsdName(template, gwid, metric string) string {
+	return strings.NewReplacer("{gateway}", statsdNameEscaper.Replace(gwid), "{metric}", metric).Replace(template)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var (
+	gwGPSLocked = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_gps_locked",
+		Help: "Whether the last status of the gateway reported a GPS lock.",
+	}, []string{"gateway"})
+	gwBatteryVolts = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_battery_volts",
+		Help: "Battery voltage reported in the last status of the gateway.",
+	}, []string{"gateway"})
+	gwBatteryPercent = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_battery_percent",
+		Help: "Battery charge reported in the last status of the gateway.",
+	}, []string{"gateway"})
+	gwTemperature = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_temperature_celsius",
+		Help: "Temperature reported in the last status of the gateway.",
+	}, []string{"gateway"})
+)
+
+// gpsLockKeys are the names vendors use to report the GPS lock state in the
+// status metrics or the advanced status fields.
+var gpsLockKeys = []string{"gps_locked", "gps_lock", "gps_fix", "gps"}
+
+// The battery keys are looked up in order, the first one present is used.
+// They can be replaced with LYTGAE_BATTERY_VOLTS_KEYS and
+// LYTGAE_BATTERY_PERCENT_KEYS.
+var (
+	batteryVoltsKeys   = []string{"battery_voltage", "battery_volts", "vbat", "bat_v"}
+	batteryPercentKeys = []string{"battery_percent", "battery_level", "battery", "bat"}
+)
+
+// temperatureKeys are the temperature keys commonly sent by packet
+// forwarders and vendor firmwares: "temperature" and "temp" for the board
+// or concentrator sensor, "cpu_temp" where only the SoC is measured. They
+// can be replaced with LYTGAE_TEMPERATURE_KEYS.
+var temperatureKeys = []string{"temperature", "temp", "concentrator_temp", "board_temp", "cpu_temp"}
+
+// publishStatus exports the metrics derived from the last status message of
+// a gateway. Most fields are optional and vendor specific, so metrics are
+// only set when the gateway reports them.
+func publishStatus(gwid string, status *ttnpb.GatewayStatus) {
+	if status == nil {
+		return
+	}
+
+	if locked, ok := gpsLocked(status); ok {
+		v := 0.0
+		if locked {
+			v = 1
+		}
+		gwGPSLocked.WithLabelValues(gwid).Set(v)
+	}
+
+	if v, ok := statusNumber(status, batteryVoltsKeys); ok {
+		gwBatteryVolts.WithLabelValues(gwid).Set(v)
+	}
+	if v, ok := statusNumber(status, batteryPercentKeys); ok {
+		gwBatteryPercent.WithLabelValues(gwid).Set(v)
+	}
+	if v, ok := statusNumber(status, temperatureKeys); ok {
+		gwTemperature.WithLabelValues(gwid).Set(v)
+	}
+
+	publishFirmwareInfo(gwid, status.GetVersions())
+	publishStatusLocation(gwid, status)
+}
+
+// statusNumber returns the value of the first of keys that is present as a
+// status metric or a numeric advanced status field.
+func statusNumber(status *ttnpb.GatewayStatus, keys []string) (float64, bool) {
+	metrics := status.GetMetrics()
+	advanced := status.GetAdvanced().GetFields()
+
+	for _, key := range keys {
+		if v, ok := metrics[key]; ok {
+			return float64(v), true
+		}
+		if v, ok := advanced[key].GetKind().(*structpb.Value_NumberValue); ok {
+			return v.NumberValue, true
+		}
+	}
+
+	return 0, false
+}
+
+func gpsLocked(status *ttnpb.GatewayStatus) (bool, bool) {
+	metrics := status.GetMetrics()
+	advanced := status.GetAdvanced().GetFields()
+
+	for _, key := range gpsLockKeys {
+		if v, ok := metrics[key]; ok {
+			return v != 0, true
+		}
+
+		v, ok := advanced[key]
+		if !ok {
+			continue
+		}
+		switch v.GetKind().(type) {
+		case *structpb.Value_BoolValue:
+			return v.GetBoolValue(), true
+		case *structpb.Value_NumberValue:
+			return v.GetNumberValue() != 0, true
+		}
+	}
+
+	return false, false
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	duplicateGatewayIDs = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "lytgae_duplicate_gateway_ids",
+		Help: "Number of gateway IDs reported by more than one server within the duplicate window.",
+	})
+	gatewaysDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "lytgae_gateways_dropped_cardinality_total",
+		Help: "Number of updates for new gateways that were dropped because the gateway limit was reached.",
+	})
+)
+
+// Store keeps the last known state of every gateway. Implementations must
+// not block the caller on I/O, as they are used from the event consumer.
+type Store interface {
+	// Upsert stores gw and reports whether it did. New gateways are
+	// rejected once the store holds the maximum number of gateways.
+	Upsert(gw Gateway) bool
+	Get(id string) (Gateway, bool)
+	// Snapshot returns all gateways sorted by ID.
+	Snapshot() []Gateway
+	Delete(id string)
+	// SetMuted mutes or unmutes a gateway and reports whether that changed
+	// its state. The mute state is kept apart from the gateway data, so
+	// updates of the gateway don't race with it.
+	SetMuted(id string, muted bool) bool
+	Muted(id string) bool
+	// Flush writes pending changes to the backend. The store must not be
+	// used afterwards.
+	Flush(ctx context.Context) error
+}
+
+// newStore returns the store selected by cfg.Store.
+func newStore(cfg *Config, clock Clock) (Store, error) {
+	mem := newMemoryStore(cfg.Server, cfg.DuplicateWindow, cfg.MaxGateways, clock)
+
+	switch cfg.Store {
+	case "", "memory":
+		return mem, nil
+	case "redis":
+		return newRedisStore(mem, cfg.RedisAddr, cfg.RedisPassword, cfg.RedisKey)
+	default:
+		return nil, fmt.Errorf("unknown store %q", cfg.Store)
+	}
+}
+
+type memoryStore struct {
+	// server is recorded with every gateway written by this process, so
+	// gateways reported by more than one cluster can be detected when
+	// several instances share a store.
+	server    string
+	dupWindow time.Duration
+	// maxGateways limits the number of gateways, and with that the
+	// cardinality of the gateway label. Zero means no limit.
+	maxGateways int
+	clock       Clock
+
+	mu         sync.RWMutex
+	gateways   map[string]Gateway
+	duplicates map[string]time.Time
+	muted      map[string]bool
+}
+
+func newMemoryStore(server string, dupWindow time.Duration, maxGateways int, clock Clock) *memoryStore {
+	return &memoryStore{
+		server:      server,
+		dupWindow:   dupWindow,
+		maxGateways: maxGateways,
+		clock:       clock,
+		gateways:    make(map[string]Gateway),
+		duplicates:  make(map[string]time.Time),
+		muted:       make(map[string]bool),
+	}
+}
+
+func (s *memoryStore) Upsert(gw Gateway) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.gateways[gw.id]; !ok && s.maxGateways > 0 && len(s.gateways) >= s.maxGateways {
+		gatewaysDropped.Inc()
+		debugf("gateway limit reached, dropping %s", gw.id)
+		return false
+	}
+
+	gw.server = s.server
+	s.gateways[gw.id] = gw
+	return true
+}
+
+func (s *memoryStore) Get(id string) (Gateway, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	gw, ok := s.gateways[id]
+	return gw, ok
+}
+
+func (s *memoryStore) Snapshot() []Gateway {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rtn := make([]Gateway, 0, len(s.gateways))
+	for _, gw := range s.gateways {
+		rtn = append(rtn, gw)
+	}
+	slices.SortFunc(rtn, func(a, b Gateway) int {
+		return cmp.Compare(a.id, b.id)
+	})
+
+	return rtn
+}
+
+func (s *memoryStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.gateways, id)
+	delete(s.muted, id)
+}
+
+func (s *memoryStore) SetMuted(id string, muted bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.muted[id] == muted {
+		return false
+	}
+	if muted {
+		s.muted[id] = true
+	} else {
+		delete(s.muted, id)
+	}
+	return true
+}
+
+func (s *memoryStore) Muted(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.muted[id]
+}
+
+// setMutes replaces the muted gateways.
+func (s *memoryStore) setMutes(ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.muted = make(map[string]bool, len(ids))
+	for _, id := range ids {
+		s.muted[id] = true
+	}
+}
+
+func (s *memoryStore) Flush(ctx context.Context) error {
+	return nil
+}
+
+// merge stores gw unless the store already has newer data for it. The
+// gateway limit applies to merged gateways as well, replicas with a higher
+// limit must not grow this one beyond its own.
+func (s *memoryStore) merge(gw Gateway) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, ok := s.gateways[gw.id]
+	if !ok && s.maxGateways > 0 && len(s.gateways) >= s.maxGateways {
+		gatewaysDropped.Inc()
+		debugf("gateway limit reached, not merging %s", gw.id)
+		return
+	}
+	if ok && cur.server != gw.server && cur.server != "" && gw.server != "" {
+		d := gw.lastSeen.Sub(cur.lastSeen)
+		if d < s.dupWindow && -d < s.dupWindow {
+			log.Printf("Gateway %s is reported by %s and %s", gw.id, cur.server, gw.server)
+			s.duplicates[gw.id] = s.clock.Now()
+		}
+	}
+
+	if ok && !gw.lastSeen.After(cur.lastSeen) {
+		return
+	}
+	s.gateways[gw.id] = gw
+}
+
+// updateDuplicates exports the number of gateway IDs seen on more than one
+// server within the duplicate window.
+func (s *memoryStore) updateDuplicates(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, t := range s.duplicates {
+		if now.Sub(t) > s.dupWindow {
+			delete(s.duplicates, id)
+		}
+	}
+	duplicateGatewayIDs.Set(float64(len(s.duplicates)))
+}
@@ -0,0 +1,9 @@
+//go:build !redis
+
+package main
+
+import "fmt"
+
+func newRedisStore(mem *memoryStore, addr string, password string, key string) (Store, error) {
+	return nil, fmt.Errorf("lytgae was built without redis support, rebuild with -tags redis")
+}
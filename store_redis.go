@@ -0,0 +1,388 @@
+//go:build redis
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	redisQueueSize    = 1024
+	redisSyncInterval = 10 * time.Second
+	redisTimeout      = 5 * time.Second
+)
+
+// redisStore serves all reads from memory and writes changes to a Redis
+// hash in the background, so replicas can share the gateway state. Entries
+// written by other replicas are merged in every redisSyncInterval, which is
+// also when gateways reported by instances for different clusters are
+// noticed.
+type redisStore struct {
+	*memoryStore
+
+	addr     string
+	password string
+	key      string
+	queue    chan []string
+	conn     *respConn
+	// flushed is closed to stop the writer, which closes done after
+	// writing the queued commands.
+	flushed chan struct{}
+	done    chan struct{}
+}
+
+func newRedisStore(mem *memoryStore, addr string, password string, key string) (Store, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("LYTGAE_REDIS_ADDR is not set")
+	}
+
+	s := &redisStore{
+		memoryStore: mem,
+		addr:        addr,
+		password:    password,
+		key:         key,
+		queue:       make(chan []string, redisQueueSize),
+		flushed:     make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	if err := s.load(); err != nil {
+		return nil, fmt.Errorf("redis: %v", err)
+	}
+	spawn(s.run)
+
+	return s, nil
+}
+
+func (s *redisStore) Upsert(gw Gateway) bool {
+	if !s.memoryStore.Upsert(gw) {
+		return false
+	}
+	gw.server = s.server
+
+	b, err := json.Marshal(gatewayToJSON(gw))
+	if err != nil {
+		log.Printf("redis: %v", err)
+		return true
+	}
+	s.enqueue("HSET", s.key, gw.id, string(b))
+	return true
+}
+
+func (s *redisStore) Delete(id string) {
+	s.memoryStore.Delete(id)
+	s.enqueue("HDEL", s.key, id)
+	s.enqueue("SREM", s.mutesKey(), id)
+}
+
+// SetMuted keeps the muted gateways in a set next to the hash, so replicas
+// writing the gateway data can't overwrite them.
+func (s *redisStore) SetMuted(id string, muted bool) bool {
+	if !s.memoryStore.SetMuted(id, muted) {
+		return false
+	}
+	if muted {
+		s.enqueue("SADD", s.mutesKey(), id)
+	} else {
+		s.enqueue("SREM", s.mutesKey(), id)
+	}
+	return true
+}
+
+func (s *redisStore) mutesKey() string {
+	return s.key + ":muted"
+}
+
+// enqueue hands a command to the writer without blocking. It is dropped if
+// the writer can not keep up; the next Upsert of the gateway repairs it.
+func (s *redisStore) enqueue(cmd ...string) {
+	select {
+	case s.queue <- cmd:
+	default:
+		log.Printf("redis: queue full, dropping %s", cmd[0])
+	}
+}
+
+// Flush stops the writer after it wrote all queued commands, or returns
+// the context error if that takes too long.
+func (s *redisStore) Flush(ctx context.Context) error {
+	close(s.flushed)
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("redis: flush: %v", ctx.Err())
+	}
+}
+
+func (s *redisStore) run() {
+	defer close(s.done)
+
+	t := s.clock.NewTicker(redisSyncInterval)
+	defer t.Stop()
+
+	for {
+		var err error
+		select {
+		case <-s.flushed:
+			s.flush()
+			return
+		case cmd := <-s.queue:
+			_, err = s.do(cmd...)
+		case <-t.C():
+			// Queued mutes must not be undone by the set loaded.
+			s.flush()
+			err = s.load()
+		}
+		if err != nil {
+			log.Printf("redis: %v", err)
+		}
+	}
+}
+
+// flush writes all queued commands.
+func (s *redisStore) flush() {
+	for {
+		select {
+		case cmd := <-s.queue:
+			if _, err := s.do(cmd...); err != nil {
+				log.Printf("redis: %v", err)
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// load merges the gateways stored in Redis into memory.
+func (s *redisStore) load() error {
+	reply, err := s.do("HGETALL", s.key)
+	if err != nil {
+		return err
+	}
+
+	fields, ok := reply.([]any)
+	if !ok {
+		return fmt.Errorf("HGETALL: unexpected reply %T", reply)
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		v, _ := fields[i+1].(string)
+
+		var gj gatewayJSON
+		if err := json.Unmarshal([]byte(v), &gj); err != nil {
+			log.Printf("redis: %v: %v", fields[i], err)
+			continue
+		}
+		s.merge(gj.gateway())
+	}
+	s.updateDuplicates(s.clock.Now())
+
+	reply, err = s.do("SMEMBERS", s.mutesKey())
+	if err != nil {
+		return err
+	}
+	members, ok := reply.([]any)
+	if !ok {
+		return fmt.Errorf("SMEMBERS: unexpected reply %T", reply)
+	}
+	ids := make([]string, 0, len(members))
+	for _, m := range members {
+		if id, ok := m.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	s.setMutes(ids)
+
+	return nil
+}
+
+// do runs a single command, (re)connecting if necessary.
+func (s *redisStore) do(args ...string) (any, error) {
+	if s.conn == nil {
+		conn, err := dialRESP(s.addr, s.password)
+		if err != nil {
+			return nil, err
+		}
+		s.conn = conn
+	}
+
+	reply, err := s.conn.do(args...)
+	if _, ok := err.(respError); err != nil && !ok {
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	return reply, err
+}
+
+type gatewayJSON struct {
+	ID            string    `json:"id"`
+	LastSeen      time.Time `json:"last_seen"`
+	Server        string    `json:"server"`
+	Protocol      string    `json:"protocol"`
+	ConnectTime   time.Time `json:"connect_time"`
+	DisconnTime   time.Time `json:"disconnect_time"`
+	UplinkTime    time.Time `json:"uplink_time"`
+	UplinkCount   uint64    `json:"uplink_count"`
+	DownlinkTime  time.Time `json:"downlink_time"`
+	DownlinkCount uint64    `json:"downlink_count"`
+	TxAckTime     time.Time `json:"txack_time"`
+	TxAckCount    uint64    `json:"txack_count"`
+
+	StatsTime     time.Time     `json:"stats_time"`
+	StatsInterval time.Duration `json:"stats_interval"`
+
+	SNRAvg     float64 `json:"snr_avg"`
+	SNRSamples uint64  `json:"snr_samples"`
+
+	UplinkBytes   uint64 `json:"uplink_bytes"`
+	DownlinkBytes uint64 `json:"downlink_bytes"`
+
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+func gatewayToJSON(gw Gateway) gatewayJSON {
+	return gatewayJSON{
+		ID:            gw.id,
+		LastSeen:      gw.lastSeen,
+		Server:        gw.server,
+		Protocol:      gw.protocol,
+		ConnectTime:   gw.connectTime,
+		DisconnTime:   gw.disconnTime,
+		UplinkTime:    gw.uplinkTime,
+		UplinkCount:   gw.uplinkCount,
+		DownlinkTime:  gw.downlinkTime,
+		DownlinkCount: gw.downlinkCount,
+		TxAckTime:     gw.txAckTime,
+		TxAckCount:    gw.txAckCount,
+		StatsTime:     gw.statsTime,
+		StatsInterval: gw.statsInterval,
+		SNRAvg:        gw.snrAvg,
+		SNRSamples:    gw.snrSamples,
+		UplinkBytes:   gw.uplinkBytes,
+		DownlinkBytes: gw.downlinkBytes,
+		ConnectedAt:   gw.connectedAt,
+	}
+}
+
+func (gj gatewayJSON) gateway() Gateway {
+	return Gateway{
+		id:            gj.ID,
+		lastSeen:      gj.LastSeen,
+		server:        gj.Server,
+		protocol:      gj.Protocol,
+		connectTime:   gj.ConnectTime,
+		disconnTime:   gj.DisconnTime,
+		uplinkTime:    gj.UplinkTime,
+		uplinkCount:   gj.UplinkCount,
+		downlinkTime:  gj.DownlinkTime,
+		downlinkCount: gj.DownlinkCount,
+		txAckTime:     gj.TxAckTime,
+		txAckCount:    gj.TxAckCount,
+		statsTime:     gj.StatsTime,
+		statsInterval: gj.StatsInterval,
+		snrAvg:        gj.SNRAvg,
+		snrSamples:    gj.SNRSamples,
+		uplinkBytes:   gj.UplinkBytes,
+		downlinkBytes: gj.DownlinkBytes,
+		connectedAt:   gj.ConnectedAt,
+	}
+}
+
+// respConn is a minimal client for the Redis protocol (RESP2), which is
+// all the store needs.
+type respConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// respError is an error reply sent by the server.
+type respError string
+
+func (e respError) Error() string { return string(e) }
+
+func dialRESP(addr string, password string) (*respConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &respConn{Conn: conn, r: bufio.NewReader(conn)}
+
+	if password != "" {
+		if _, err := c.do("AUTH", password); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("AUTH: %v", err)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *respConn) do(args ...string) (any, error) {
+	c.SetDeadline(time.Now().Add(redisTimeout))
+
+	w := bufio.NewWriter(c)
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return c.read()
+}
+
+func (c *respConn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("short reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, respError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		rtn := make([]any, n)
+		for i := range rtn {
+			if rtn[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return rtn, nil
+	default:
+		return nil, fmt.Errorf("unknown reply type %q", kind)
+	}
+}
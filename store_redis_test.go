@@ -0,0 +1,123 @@
+//go:build redis
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRESPRead(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		reply string
+		want  any
+		err   bool
+	}{
+		{name: "simple", reply: "+OK\r\n", want: "OK"},
+		{name: "integer", reply: ":42\r\n", want: int64(42)},
+		{name: "bulk", reply: "$5\r\nhello\r\n", want: "hello"},
+		{name: "bulk with newline", reply: "$7\r\na\r\nb\r\nc\r\n", want: "a\r\nb\r\nc"},
+		{name: "empty bulk", reply: "$0\r\n\r\n", want: ""},
+		{name: "nil bulk", reply: "$-1\r\n", want: nil},
+		{name: "array", reply: "*2\r\n$2\r\nid\r\n$2\r\n{}\r\n", want: []any{"id", "{}"}},
+		{name: "empty array", reply: "*0\r\n", want: []any{}},
+		{name: "nested", reply: "*2\r\n:1\r\n*1\r\n+x\r\n", want: []any{int64(1), []any{"x"}}},
+		{name: "error", reply: "-ERR wrong\r\n", err: true},
+		{name: "unknown type", reply: "?x\r\n", err: true},
+		{name: "short", reply: "+\n", err: true},
+		{name: "truncated bulk", reply: "$10\r\nabc", err: true},
+		{name: "truncated array", reply: "*2\r\n+a\r\n", err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &respConn{r: bufio.NewReader(strings.NewReader(tc.reply))}
+			got, err := c.read()
+			if tc.err {
+				if err == nil {
+					t.Fatalf("read = %#v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("read = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRESPErrorReply(t *testing.T) {
+	c := &respConn{r: bufio.NewReader(strings.NewReader("-WRONGPASS invalid password\r\n"))}
+	_, err := c.read()
+	if _, ok := err.(respError); !ok {
+		t.Fatalf("read error = %#v, want respError", err)
+	}
+	if err.Error() != "WRONGPASS invalid password" {
+		t.Errorf("error = %q", err)
+	}
+}
+
+func TestRESPDo(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	want := "*3\r\n$4\r\nHGET\r\n$3\r\nkey\r\n$4\r\ngw-1\r\n"
+	got := make(chan string, 1)
+	go func() {
+		buf := make([]byte, len(want))
+		_, err := io.ReadFull(server, buf)
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		got <- string(buf)
+		server.Write([]byte("$2\r\n{}\r\n"))
+	}()
+
+	c := &respConn{Conn: client, r: bufio.NewReader(client)}
+	reply, err := c.do("HGET", "key", "gw-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "{}" {
+		t.Errorf("reply = %#v, want {}", reply)
+	}
+
+	select {
+	case req := <-got:
+		if req != want {
+			t.Errorf("request = %q, want %q", req, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no request received")
+	}
+}
+
+func TestGatewayJSONRoundTrip(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	gw := Gateway{
+		id:            "gw",
+		lastSeen:      now,
+		server:        "eu1",
+		protocol:      "udp",
+		connectTime:   now.Add(-time.Hour),
+		uplinkTime:    now,
+		uplinkCount:   3,
+		statsInterval: 30 * time.Second,
+		snrAvg:        7.5,
+		snrSamples:    3,
+		uplinkBytes:   42,
+	}
+
+	if got := gatewayToJSON(gw).gateway(); !reflect.DeepEqual(got, gw) {
+		t.Errorf("round trip = %+v, want %+v", got, gw)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMemoryStoreLimit(t *testing.T) {
+	s := newMemoryStore("a", time.Minute, 2, realClock{})
+	now := time.Now()
+
+	for _, id := range []string{"gw-1", "gw-2"} {
+		if !s.Upsert(Gateway{id: id, lastSeen: now}) {
+			t.Fatalf("Upsert(%s) rejected below the limit", id)
+		}
+	}
+	dropped := testutil.ToFloat64(gatewaysDropped)
+	if s.Upsert(Gateway{id: "gw-3", lastSeen: now}) {
+		t.Errorf("Upsert accepted a gateway beyond the limit")
+	}
+	if !s.Upsert(Gateway{id: "gw-1", lastSeen: now.Add(time.Second)}) {
+		t.Errorf("Upsert rejected an update of a known gateway at the limit")
+	}
+
+	s.merge(Gateway{id: "gw-4", lastSeen: now, server: "b"})
+	if _, ok := s.Get("gw-4"); ok {
+		t.Errorf("merge added a gateway beyond the limit")
+	}
+	s.merge(Gateway{id: "gw-2", lastSeen: now.Add(time.Second), server: "b", uplinkCount: 7})
+	if gw, _ := s.Get("gw-2"); gw.uplinkCount != 7 {
+		t.Errorf("merge did not update a known gateway at the limit")
+	}
+
+	if got := testutil.ToFloat64(gatewaysDropped) - dropped; got != 2 {
+		t.Errorf("lytgae_gateways_dropped_cardinality_total increased by %g, want 2", got)
+	}
+	if n := len(s.Snapshot()); n != 2 {
+		t.Errorf("store holds %d gateways, want 2", n)
+	}
+}
+
+func TestMemoryStoreMergeKeepsNewer(t *testing.T) {
+	s := newMemoryStore("a", time.Minute, 0, realClock{})
+	now := time.Now()
+
+	s.Upsert(Gateway{id: "gw", lastSeen: now, uplinkCount: 2})
+	s.merge(Gateway{id: "gw", lastSeen: now.Add(-time.Second), server: "a", uplinkCount: 1})
+	if gw, _ := s.Get("gw"); gw.uplinkCount != 2 {
+		t.Errorf("merge replaced newer data, uplinkCount = %d", gw.uplinkCount)
+	}
+}
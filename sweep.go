@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var gatewaysWithoutEvents = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "lytgae_gateways_without_events",
+	Help: "Number of subscribed gateways that have not sent an event yet.",
+})
+
+// sweep periodically updates the metrics that are derived from the state
+// of all clients until ctx is done.
+func sweep(ctx context.Context, clock Clock, clients []*Client, interval time.Duration) {
+	t := clock.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+		}
+
+		n := 0
+		for _, c := range clients {
+			n += c.gatewaysWithoutEvents()
+		}
+		gatewaysWithoutEvents.Set(float64(n))
+	}
+}
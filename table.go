@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"text/tabwriter"
+	"time"
+)
+
+// logGatewayTable logs the gateways of the store as a table, for a quick
+// look at a running instance.
+func logGatewayTable(store Store, now time.Time) {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GATEWAY\tSTATE\tUPLINKS\tDOWNLINKS\tTXACKS\tLAST UPLINK\tLAST STATS")
+	gws := store.Snapshot()
+	for _, gw := range gws {
+		state := "down"
+		if gw.connected() {
+			state = "up"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\t%s\n", gw.id, state,
+			gw.uplinkCount, gw.downlinkCount, gw.txAckCount,
+			formatAge(now, gw.uplinkTime, gw.uplinkCount != 0), formatAge(now, gw.lastSeen, true))
+	}
+	w.Flush()
+
+	log.Printf("%d gateways:\n%s", len(gws), buf.String())
+}
+
+// formatAge returns how long ago t was, or "-" if ok is false.
+func formatAge(now, t time.Time, ok bool) string {
+	if !ok || t.IsZero() {
+		return "-"
+	}
+
+	return now.Sub(t).Truncate(time.Second).String() + " ago"
+}
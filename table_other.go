@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "context"
+
+// logTableOnSignal does nothing, there is no SIGUSR1 on this platform.
+func logTableOnSignal(ctx context.Context, store Store) {}
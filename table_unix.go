@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// logTableOnSignal logs the gateway table whenever the process receives
+// SIGUSR1, until ctx is done.
+func logTableOnSignal(ctx context.Context, store Store) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+			logGatewayTable(store, time.Now())
+		}
+	}
+}